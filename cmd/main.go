@@ -2,6 +2,7 @@ package main
 
 // Usage:
 // `vaultclient get <url-path-to-secret>`
+// `vaultclient --method oidc --oidc-role <role> get <url-path-to-secret>`
 
 import (
 	"encoding/json"
@@ -13,13 +14,62 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// oidcAuthType is the auth type the interactive OIDC login is registered as.
+const oidcAuthType = vault.CustomAuthType
+
+// oidcMethod is the --method that logs in through the browser. Without a
+// --method the auth is taken from env vars.
+const oidcMethod = "oidc"
+
 func main() {
-	app := &cli.App{
+	err := newApp().Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newApp() *cli.App {
+	return &cli.App{
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "method",
+				Usage:   "auth method to log in with, '" + oidcMethod + "' for a browser login, otherwise taken from env vars",
+				EnvVars: []string{"VAULT_CLIENT_METHOD"},
+			},
+			&cli.StringFlag{
+				Name:  "oidc-path",
+				Usage: "mount of the oidc auth backend",
+				Value: defaultOidcPath,
+			},
+			&cli.StringFlag{
+				Name:  "oidc-role",
+				Usage: "oidc role to log in as, the backend's default role when empty",
+			},
+			&cli.IntFlag{
+				Name:  "oidc-port",
+				Usage: "port to listen on at " + oidcCallbackHost + " for the oidc callback",
+				Value: defaultOidcCallbackPort,
+			},
+			&cli.StringFlag{
+				Name:    "token-cache",
+				Usage:   "file to cache the token from an oidc login in",
+				EnvVars: []string{"VAULT_TOKEN_CACHE_PATH"},
+			},
+			&cli.StringFlag{
+				Name:    "token-cache-key-file",
+				Usage:   "file holding the token cache key, read from VAULT_TOKEN_CACHE_KEY when empty",
+				EnvVars: []string{"VAULT_TOKEN_CACHE_KEY_FILE"},
+			},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "get",
 				Usage: "get secret from a path",
 				Action: func(c *cli.Context) error {
+					if err := configure(c); err != nil {
+						return err
+					}
+
 					secret, err := getSecret(c.Args().First())
 					if err != nil {
 						return err
@@ -31,19 +81,53 @@ func main() {
 			},
 		},
 	}
+}
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+func configure(c *cli.Context) error {
+	switch method := c.String("method"); method {
+	case "":
+		if err := vault.ConfigureDefault(); err != nil {
+			return fmt.Errorf("could not configure vault: %w", err)
+		}
+		return nil
+	case oidcMethod:
+		return configureOidc(c)
+	default:
+		return fmt.Errorf("unknown auth method '%s', supported methods are '%s', or none to take the auth from env vars", method, oidcMethod)
 	}
 }
 
-func getSecret(path string) (string, error) {
-	err := vault.ConfigureDefault()
+func configureOidc(c *cli.Context) error {
+	err := vault.RegisterAuthMethod(oidcAuthType, func(cfg *vault.Config) (vault.AuthMethod, error) {
+		return &oidcAuth{
+			path:    c.String("oidc-path"),
+			role:    c.String("oidc-role"),
+			port:    c.Int("oidc-port"),
+			openURL: openBrowser,
+			out:     os.Stderr,
+		}, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("could not configure vault: %w", err)
+		return err
 	}
 
+	config := vault.BaseConfig()
+	config.AuthType = oidcAuthType
+	if tokenCache := c.String("token-cache"); tokenCache != "" {
+		config.TokenCachePath = tokenCache
+		config.TokenCacheKey = vault.TokenCacheKeyFromEnv("VAULT_TOKEN_CACHE_KEY")
+		if keyFile := c.String("token-cache-key-file"); keyFile != "" {
+			config.TokenCacheKey = vault.TokenCacheKeyFromFile(keyFile)
+		}
+	}
+
+	if err := vault.Configure(config); err != nil {
+		return fmt.Errorf("could not configure vault: %w", err)
+	}
+	return nil
+}
+
+func getSecret(path string) (string, error) {
 	secrets, err := vault.ReadData(path)
 	if err != nil {
 		return "", fmt.Errorf("could not read secrets from path '%s': %w", path, err)
@@ -33,3 +33,12 @@ func TestGetSecretWithValidPath(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, `{"foo":"bar"}`, secret, "expected returned secret to equal the stored data in vault")
 }
+
+func TestUnknownMethodIsRejected(t *testing.T) {
+	err := newApp().Run([]string{"vaultclient", "--method", "kerberos", "get", "secret/foo"})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown auth method 'kerberos'")
+		assert.Contains(t, err.Error(), "'oidc'")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultOidcPath         = "oidc"
+	defaultOidcCallbackPort = 8250
+
+	// oidcCallbackHost is both listened on and given to Vault in the
+	// redirect uri, as the Vault CLI does, so that the browser is sent to
+	// the address the listener is on.
+	oidcCallbackHost = "localhost"
+
+	oidcCallbackPath    = "/oidc/callback"
+	oidcCallbackSuccess = "<html><body>Vault login successful, you can close this window.</body></html>"
+	oidcCallbackFailure = "<html><body>Vault login failed, check your terminal for details.</body></html>"
+)
+
+// oidcAuth logs in through Vault's OIDC auth backend by sending the user to
+// their OIDC provider in a browser and catching the redirect back on a
+// localhost listener.
+type oidcAuth struct {
+	path string
+	role string
+	port int
+
+	// openURL sends the user to the OIDC provider, and out is where the auth
+	// url is printed in case the browser cannot be opened.
+	openURL func(url string) error
+	out     io.Writer
+}
+
+type oidcCallback struct {
+	state   string
+	code    string
+	idToken string
+	err     error
+}
+
+func (o *oidcAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(oidcCallbackHost, strconv.Itoa(o.port)))
+	if err != nil {
+		return nil, fmt.Errorf("starting oidc callback listener: %w", err)
+	}
+	defer listener.Close()
+
+	redirectURI := fmt.Sprintf("http://%s%s", net.JoinHostPort(oidcCallbackHost, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)), oidcCallbackPath)
+	nonce := make([]byte, 20)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating oidc client nonce: %w", err)
+	}
+	clientNonce := hex.EncodeToString(nonce)
+
+	authURL, err := o.authURL(ctx, client, redirectURI, clientNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	callbackCh := make(chan oidcCallback, 1)
+	server := &http.Server{Handler: oidcCallbackHandler(callbackCh)}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	fmt.Fprintf(o.out, "Complete the login via your OIDC provider. Launching browser to:\n\n    %s\n\n", authURL)
+	if err := o.openURL(authURL); err != nil {
+		fmt.Fprintf(o.out, "Could not open a browser (%s), open the url above manually.\n", err)
+	}
+
+	var callback oidcCallback
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case callback = <-callbackCh:
+	}
+	if callback.err != nil {
+		return nil, callback.err
+	}
+
+	return o.exchange(ctx, client, callback, clientNonce)
+}
+
+// CacheIdentity keeps tokens for different oidc mounts and roles apart in the
+// token cache.
+func (o *oidcAuth) CacheIdentity() string {
+	return fmt.Sprintf("oidc %q %q", o.path, o.role)
+}
+
+// authURL asks Vault for the url of the OIDC provider to send the user to.
+func (o *oidcAuth) authURL(ctx context.Context, client *api.Client, redirectURI, clientNonce string) (string, error) {
+	r := client.NewRequest("PUT", fmt.Sprintf("/v1/auth/%s/oidc/auth_url", o.path))
+	if err := r.SetJSONBody(map[string]interface{}{
+		"role":         o.role,
+		"redirect_uri": redirectURI,
+		"client_nonce": clientNonce,
+	}); err != nil {
+		return "", err
+	}
+	secret, err := doRequest(ctx, client, r)
+	if err != nil {
+		return "", fmt.Errorf("requesting oidc auth url: %w", err)
+	}
+
+	authURL := ""
+	if secret != nil {
+		authURL, _ = secret.Data["auth_url"].(string)
+	}
+	if authURL == "" {
+		return "", fmt.Errorf("no oidc auth url returned, check that '%s' is an allowed redirect uri for the role", redirectURI)
+	}
+	return authURL, nil
+}
+
+// exchange hands the code from the OIDC provider to Vault in return for a
+// token.
+func (o *oidcAuth) exchange(ctx context.Context, client *api.Client, callback oidcCallback, clientNonce string) (*api.Secret, error) {
+	r := client.NewRequest("GET", fmt.Sprintf("/v1/auth/%s/oidc/callback", o.path))
+	r.Params.Set("state", callback.state)
+	r.Params.Set("code", callback.code)
+	r.Params.Set("id_token", callback.idToken)
+	r.Params.Set("client_nonce", clientNonce)
+
+	secret, err := doRequest(ctx, client, r)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging oidc code: %w", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("no token returned from oidc callback")
+	}
+	return secret, nil
+}
+
+func oidcCallbackHandler(callbackCh chan<- oidcCallback) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		callback := oidcCallback{
+			state:   query.Get("state"),
+			code:    query.Get("code"),
+			idToken: query.Get("id_token"),
+		}
+		if providerErr := query.Get("error"); providerErr != "" {
+			callback.err = fmt.Errorf("oidc provider returned error '%s': %s", providerErr, query.Get("error_description"))
+		} else if callback.code == "" {
+			callback.err = errors.New("oidc provider returned no code")
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if callback.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, oidcCallbackFailure)
+		} else {
+			_, _ = io.WriteString(w, oidcCallbackSuccess)
+		}
+
+		// only the first callback counts
+		select {
+		case callbackCh <- callback:
+		default:
+		}
+	})
+	return mux
+}
+
+func doRequest(ctx context.Context, client *api.Client, r *api.Request) (*api.Secret, error) {
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.ParseSecret(resp.Body)
+}
+
+// openBrowser opens url in the default browser of the user.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	vc "github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOidcLogin(t *testing.T) {
+	server := newStandInOidcServer(t, "")
+	defer server.Close()
+
+	v := newOidcVaultAuth(t, server, nil)
+
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "oidc-token", client.Token())
+	assert.Equal(t, 1, server.logins())
+}
+
+func TestOidcLoginWhenProviderReturnsError(t *testing.T) {
+	server := newStandInOidcServer(t, "access_denied")
+	defer server.Close()
+
+	v := newOidcVaultAuth(t, server, nil)
+
+	_, err := v.VaultClient()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "access_denied")
+	assert.Equal(t, 0, server.logins())
+}
+
+func TestOidcLoginCachesToken(t *testing.T) {
+	server := newStandInOidcServer(t, "")
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "oidc-token-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := func(config *vc.Config) {
+		config.TokenCachePath = filepath.Join(dir, "token")
+		config.TokenCacheKey = func() ([]byte, error) {
+			return []byte("cache key"), nil
+		}
+	}
+
+	_, err = newOidcVaultAuth(t, server, cache).VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second run picks the token up from the cache instead of logging in
+	client, err := newOidcVaultAuth(t, server, cache).VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "oidc-token", client.Token())
+	assert.Equal(t, 1, server.logins())
+}
+
+func newOidcVaultAuth(t *testing.T, server *standInOidcServer, configure func(*vc.Config)) vc.VaultAuth {
+	config := vc.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	if configure != nil {
+		configure(config)
+	}
+
+	v, err := vc.NewVaultAuthWithMethod(config, &oidcAuth{
+		path: "oidc",
+		role: "engineer",
+		// let the os pick a free port for the callback
+		port:    0,
+		openURL: server.browse,
+		out:     ioutil.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// standInOidcServer plays both Vault's oidc auth backend and the OIDC
+// provider, answering the provider's authorization request by redirecting
+// straight back to the callback.
+type standInOidcServer struct {
+	*httptest.Server
+	t             *testing.T
+	providerError string
+
+	mu          sync.Mutex
+	state       string
+	clientNonce string
+	loginCount  int
+}
+
+func newStandInOidcServer(t *testing.T, providerError string) *standInOidcServer {
+	s := &standInOidcServer{t: t, providerError: providerError}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/oidc/oidc/auth_url", s.authURL)
+	mux.HandleFunc("/v1/auth/oidc/oidc/callback", s.callback)
+	mux.HandleFunc("/v1/auth/token/lookup-self", s.lookupSelf)
+	mux.HandleFunc("/authorize", s.authorize)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *standInOidcServer) authURL(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{err.Error()}})
+		return
+	}
+	assert.Equal(s.t, "engineer", body["role"])
+	// the browser is sent to the host the callback listener is on
+	assert.Regexp(s.t, `^http://localhost:\d+/oidc/callback$`, body["redirect_uri"])
+
+	s.mu.Lock()
+	s.state = "state-1"
+	s.clientNonce = body["client_nonce"]
+	s.mu.Unlock()
+
+	authURL := s.URL + "/authorize?" + url.Values{
+		"state":        {"state-1"},
+		"redirect_uri": {body["redirect_uri"]},
+	}.Encode()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{"auth_url": authURL},
+	})
+}
+
+func (s *standInOidcServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{"state": {r.URL.Query().Get("state")}}
+	if s.providerError != "" {
+		query.Set("error", s.providerError)
+	} else {
+		query.Set("code", "code-1")
+	}
+	http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?"+query.Encode(), http.StatusFound)
+}
+
+func (s *standInOidcServer) callback(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	if query.Get("state") != s.state || query.Get("code") != "code-1" || query.Get("client_nonce") != s.clientNonce {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid callback"}})
+		return
+	}
+	s.loginCount++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   "oidc-token",
+			"accessor":       "oidc-accessor",
+			"lease_duration": 3600,
+			"renewable":      true,
+		},
+	})
+}
+
+func (s *standInOidcServer) lookupSelf(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"accessor":  "oidc-accessor",
+			"ttl":       3000,
+			"renewable": true,
+		},
+	})
+}
+
+// browse follows the auth url the way a browser would.
+func (s *standInOidcServer) browse(authURL string) error {
+	go func() {
+		resp, err := http.Get(authURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	return nil
+}
+
+func (s *standInOidcServer) logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loginCount
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
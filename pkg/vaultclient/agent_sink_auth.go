@@ -0,0 +1,145 @@
+package vaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+var (
+	// agentSinkPollInterval is how often the sink file is checked for a new
+	// token.
+	agentSinkPollInterval = time.Second
+)
+
+// agentSinkAuth takes the token that Vault Agent auto-auth writes to a file
+// sink. The token belongs to the agent, which keeps it alive, so it is never
+// revoked or cached.
+type agentSinkAuth struct {
+	path    string
+	wrapped bool
+
+	mu sync.Mutex
+	// sink and token are the last response-wrapped sink contents and the
+	// token unwrapped from them, as a wrapping token can only be used once.
+	sink  string
+	token string
+	// last is the token last returned by Login.
+	last string
+}
+
+func newAgentSinkAuth(cfg *Config) (AuthMethod, error) {
+	if cfg.AgentSinkPath == "" {
+		return nil, errors.New("agent sink auth requires the path of the sink file")
+	}
+	return &agentSinkAuth{
+		path:    cfg.AgentSinkPath,
+		wrapped: cfg.AgentSinkWrapped,
+	}, nil
+}
+
+func (a *agentSinkAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading agent sink: %w", err)
+	}
+	sink := strings.TrimSpace(string(data))
+	if sink == "" {
+		return nil, errors.New("agent sink is empty")
+	}
+
+	token := sink
+	if a.wrapped {
+		if token, err = a.unwrap(ctx, client, sink); err != nil {
+			return nil, err
+		}
+	}
+	secret, err := (&tokenAuth{token: token}).Login(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.last = token
+	a.mu.Unlock()
+	return secret, nil
+}
+
+// unwrap returns the token wrapped in the sink contents, unwrapping it only
+// when the agent has written a new one.
+func (a *agentSinkAuth) unwrap(ctx context.Context, client *api.Client, sink string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if sink == a.sink {
+		return a.token, nil
+	}
+
+	var wrapInfo api.SecretWrapInfo
+	if err := json.Unmarshal([]byte(sink), &wrapInfo); err != nil {
+		return "", fmt.Errorf("parsing wrapped agent sink: %w", err)
+	}
+	secret, err := unwrapWithContext(ctx, client, wrapInfo.Token)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping agent sink token: %w", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return "", errors.New("no token found in wrapped agent sink")
+	}
+
+	a.sink = sink
+	a.token = secret.Auth.ClientToken
+	return a.token, nil
+}
+
+// borrowed reports whether token is one taken from the sink, which belongs
+// to the agent.
+func (a *agentSinkAuth) borrowed(token string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return token != "" && token == a.last
+}
+
+// watch polls the sink file, calling changed whenever the agent writes to it.
+func (a *agentSinkAuth) watch(ctx context.Context, changed func(), _ func(error)) {
+	last := a.stat()
+	ticker := time.NewTicker(agentSinkPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := a.stat()
+		if current != last {
+			last = current
+			changed()
+		}
+	}
+}
+
+type agentSinkStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func (a *agentSinkAuth) stat() agentSinkStat {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return agentSinkStat{}
+	}
+	return agentSinkStat{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
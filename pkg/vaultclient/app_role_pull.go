@@ -0,0 +1,154 @@
+package vaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultAppRolePath = "approle"
+
+// pullLogin logs in with a secret_id minted with the bootstrap token, minting
+// a new one when the last one has run out. A secret_id that Vault turns down
+// is replaced once, as it may have run out without Vault saying when it
+// would.
+func (a *appRoleAuth) pullLogin(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	minted := false
+	if a.secretIdSpent() {
+		if err := a.mintSecretId(ctx, client); err != nil {
+			return nil, err
+		}
+		minted = true
+	}
+
+	secret, err := a.login(ctx, client, a.roleId, a.secretId)
+	if err != nil && !minted && isInvalidSecretId(err) {
+		if err := a.mintSecretId(ctx, client); err != nil {
+			return nil, err
+		}
+		secret, err = a.login(ctx, client, a.roleId, a.secretId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.secretIdUses > 0 {
+		a.secretIdUses--
+		if a.secretIdUses == 0 {
+			a.secretId = ""
+		}
+	}
+	return secret, nil
+}
+
+// secretIdSpent reports whether a new secret_id needs minting before the
+// next login.
+func (a *appRoleAuth) secretIdSpent() bool {
+	if a.secretId == "" {
+		return true
+	}
+	return !a.secretIdExpiry.IsZero() && a.secretIdExpiry.Before(time.Now().Add(expirationWindow))
+}
+
+// mintSecretId generates a new secret_id for the role with the bootstrap
+// token, fetching the role_id as well the first time round.
+func (a *appRoleAuth) mintSecretId(ctx context.Context, client *api.Client) error {
+	token, err := a.bootstrapToken()
+	if err != nil {
+		return fmt.Errorf("reading app role bootstrap token: %w", err)
+	}
+	rolePath := fmt.Sprintf("auth/%s/role/%s", a.path, a.role)
+
+	if a.roleId == "" {
+		secret, err := readWithTokenContext(ctx, client, token, rolePath+"/role-id")
+		if err != nil {
+			return fmt.Errorf("reading app role role id: %w", err)
+		}
+		if secret == nil {
+			return errors.New("no app role role id returned by vault")
+		}
+		roleId, _ := secret.Data["role_id"].(string)
+		if roleId == "" {
+			return errors.New("no app role role id returned by vault")
+		}
+		a.roleId = roleId
+	}
+
+	data := map[string]interface{}{}
+	if len(a.secretIdMetadata) > 0 {
+		metadata, err := json.Marshal(a.secretIdMetadata)
+		if err != nil {
+			return err
+		}
+		data["metadata"] = string(metadata)
+	}
+	if len(a.secretIdCidrs) > 0 {
+		data["cidr_list"] = strings.Join(a.secretIdCidrs, ",")
+	}
+
+	secret, err := writeWithTokenContext(ctx, client, token, rolePath+"/secret-id", data)
+	if err != nil {
+		return fmt.Errorf("generating app role secret id: %w", err)
+	}
+	if secret == nil {
+		return errors.New("no app role secret id returned by vault")
+	}
+	secretId, _ := secret.Data["secret_id"].(string)
+	if secretId == "" {
+		return errors.New("no app role secret id returned by vault")
+	}
+
+	// Vault only says how long the secret_id lasts, and for how many logins,
+	// when it is looked up
+	lookup, err := writeWithTokenContext(ctx, client, token, rolePath+"/secret-id/lookup", map[string]interface{}{
+		"secret_id": secretId,
+	})
+	if err != nil {
+		return fmt.Errorf("looking up app role secret id: %w", err)
+	}
+	if lookup == nil {
+		return errors.New("no app role secret id lookup returned by vault")
+	}
+
+	a.secretId = secretId
+	a.secretIdExpiry = time.Time{}
+	if ttl := dataInt(lookup.Data, "secret_id_ttl"); ttl > 0 {
+		a.secretIdExpiry = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	a.secretIdUses = int(dataInt(lookup.Data, "secret_id_num_uses"))
+	return nil
+}
+
+// isInvalidSecretId reports whether err is Vault turning down a secret_id,
+// which it does alike for one that is unknown, expired or used up.
+func isInvalidSecretId(err error) bool {
+	var respErr *api.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != 400 {
+		return false
+	}
+	for _, e := range respErr.Errors {
+		if strings.Contains(strings.ToLower(e), "secret id") {
+			return true
+		}
+	}
+	return false
+}
+
+// dataInt returns the integer held in data under key, or zero if there is
+// none.
+func dataInt(data map[string]interface{}, key string) int64 {
+	n, ok := data[key].(json.Number)
+	if !ok {
+		return 0
+	}
+	i, _ := n.Int64()
+	return i
+}
@@ -0,0 +1,140 @@
+package vaultclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultAzurePath         = "azure"
+	defaultAzureResource     = "https://management.azure.com/"
+	defaultAzureImdsEndpoint = "http://169.254.169.254"
+
+	azureInstanceApiVersion = "2021-02-01"
+	azureTokenApiVersion    = "2018-02-01"
+)
+
+type azureAuth struct {
+	role     string
+	path     string
+	resource string
+	clientId string
+
+	// imdsEndpoint is where the instance metadata and managed identity token
+	// are fetched.
+	imdsEndpoint string
+	httpClient   *http.Client
+}
+
+// azureInstance is the part of the instance metadata needed to log in.
+type azureInstance struct {
+	Compute struct {
+		SubscriptionId    string `json:"subscriptionId"`
+		ResourceGroupName string `json:"resourceGroupName"`
+		Name              string `json:"name"`
+		VmScaleSetName    string `json:"vmScaleSetName"`
+	} `json:"compute"`
+}
+
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+}
+
+func newAzureAuth(cfg *Config) (AuthMethod, error) {
+	a := &azureAuth{
+		role:         cfg.AzureRole,
+		path:         cfg.AzurePath,
+		resource:     cfg.AzureResource,
+		clientId:     cfg.AzureClientId,
+		imdsEndpoint: cfg.AzureImdsEndpoint,
+		httpClient:   newMetadataClient(),
+	}
+	if a.path == "" {
+		a.path = defaultAzurePath
+	}
+	if a.resource == "" {
+		a.resource = defaultAzureResource
+	}
+	if a.imdsEndpoint == "" {
+		a.imdsEndpoint = defaultAzureImdsEndpoint
+	}
+	a.imdsEndpoint = strings.TrimSuffix(a.imdsEndpoint, "/")
+	return a, nil
+}
+
+func (a *azureAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	instance, err := a.instance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwt, err := a.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role":                a.role,
+		"jwt":                 jwt,
+		"subscription_id":     instance.Compute.SubscriptionId,
+		"resource_group_name": instance.Compute.ResourceGroupName,
+		"vm_name":             instance.Compute.Name,
+	}
+	if instance.Compute.VmScaleSetName != "" {
+		data["vmss_name"] = instance.Compute.VmScaleSetName
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", a.path), data)
+}
+
+func (a *azureAuth) CacheIdentity() string {
+	return cacheIdentity(Azure, a.path, a.role, a.resource, a.clientId)
+}
+
+// instance fetches the subscription, resource group and VM or VMSS the
+// process runs on from IMDS.
+func (a *azureAuth) instance(ctx context.Context) (*azureInstance, error) {
+	query := url.Values{
+		"api-version": {azureInstanceApiVersion},
+	}
+	body, err := getMetadata(ctx, a.httpClient, fmt.Sprintf("%s/metadata/instance?%s", a.imdsEndpoint, query.Encode()), azureImdsHeader())
+	if err != nil {
+		return nil, fmt.Errorf("fetching azure instance metadata: %w", err)
+	}
+	var instance azureInstance
+	if err := json.Unmarshal(body, &instance); err != nil {
+		return nil, fmt.Errorf("parsing azure instance metadata: %w", err)
+	}
+	return &instance, nil
+}
+
+// token fetches a managed identity token for the resource from IMDS.
+func (a *azureAuth) token(ctx context.Context) (string, error) {
+	query := url.Values{
+		"api-version": {azureTokenApiVersion},
+		"resource":    {a.resource},
+	}
+	if a.clientId != "" {
+		query.Set("client_id", a.clientId)
+	}
+	body, err := getMetadata(ctx, a.httpClient, fmt.Sprintf("%s/metadata/identity/oauth2/token?%s", a.imdsEndpoint, query.Encode()), azureImdsHeader())
+	if err != nil {
+		return "", fmt.Errorf("fetching azure managed identity token: %w", err)
+	}
+	var token azureToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("parsing azure managed identity token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("no azure managed identity token returned")
+	}
+	return token.AccessToken, nil
+}
+
+func azureImdsHeader() http.Header {
+	return http.Header{"Metadata": {"true"}}
+}
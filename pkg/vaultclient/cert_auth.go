@@ -0,0 +1,130 @@
+package vaultclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultCertPath = "cert"
+
+// certAuth logs in with the TLS client certificate of the Vault client. When
+// the certificate was configured from files, they are read again before each
+// login so that a rotated certificate is picked up, and the login is made
+// through a client of its own.
+type certAuth struct {
+	role string
+	path string
+
+	certFile  string
+	keyFile   string
+	transport *http.Transport
+	client    *api.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertAuth(cfg *Config) (AuthMethod, error) {
+	path := cfg.CertPath
+	if path == "" {
+		path = defaultCertPath
+	}
+	c := &certAuth{
+		role: cfg.CertRole,
+		path: path,
+	}
+
+	certFile, keyFile := cfg.clientCert, cfg.clientKey
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = os.Getenv(api.EnvVaultClientCert), os.Getenv(api.EnvVaultClientKey)
+	}
+	if certFile == "" || keyFile == "" || cfg.Config == nil || cfg.HttpClient == nil {
+		// use whatever certificate the client has been configured with
+		return c, nil
+	}
+
+	transport, ok := cfg.HttpClient.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil {
+		return nil, fmt.Errorf("cert auth can only reload the client certificate of an *http.Transport with TLS configured")
+	}
+	c.certFile = certFile
+	c.keyFile = keyFile
+	// the transport of the config may be shared with other clients, so the
+	// certificate is only presented by a copy of it, TLS config included
+	c.transport = transport.Clone()
+	c.transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	c.transport.TLSClientConfig.GetClientCertificate = c.clientCertificate
+	if err := c.reload(); err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+
+	httpClient := *cfg.HttpClient
+	httpClient.Transport = c.transport
+	client, err := api.NewClient(&api.Config{
+		Address:    cfg.Address,
+		HttpClient: &httpClient,
+		MaxRetries: cfg.MaxRetries,
+		Timeout:    cfg.Timeout,
+		Backoff:    cfg.Backoff,
+		CheckRetry: cfg.CheckRetry,
+		Limiter:    cfg.Limiter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// no token is needed to log in, whatever VAULT_TOKEN holds
+	client.ClearToken()
+	c.client = client
+	return c, nil
+}
+
+func (c *certAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if c.client != nil {
+		if err := c.reload(); err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		client = c.client
+	}
+
+	data := map[string]interface{}{}
+	if c.role != "" {
+		data["name"] = c.role
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", c.path), data)
+}
+
+func (c *certAuth) CacheIdentity() string {
+	return cacheIdentity(Cert, c.path, c.role, c.certFile)
+}
+
+// reload reads the client certificate from disk and, if it has changed,
+// closes idle connections so that the next request presents the new one.
+func (c *certAuth) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	changed := c.cert == nil || !bytes.Equal(c.cert.Certificate[0], cert.Certificate[0])
+	c.cert = &cert
+	c.mu.Unlock()
+
+	if changed {
+		c.transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (c *certAuth) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
@@ -0,0 +1,153 @@
+package vaultclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// AuthAttempt is the outcome of one auth method of an auth chain.
+type AuthAttempt struct {
+	AuthType AuthType
+	Err      error
+}
+
+// AuthChainError is returned when every auth method in Config.AuthChain
+// failed to log in. It holds the error of each attempt, in order.
+type AuthChainError struct {
+	Attempts []AuthAttempt
+}
+
+func (e *AuthChainError) Error() string {
+	attempts := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		attempts = append(attempts, fmt.Sprintf("%s: %s", attempt.AuthType, attempt.Err))
+	}
+	return fmt.Sprintf("all auth methods failed to log in: %s", strings.Join(attempts, "; "))
+}
+
+// chainAuth logs in with the first of its auth methods that succeeds.
+type chainAuth struct {
+	authTypes []AuthType
+	methods   []AuthMethod
+
+	// current is the index of the method that last logged in, -1 before
+	// the first login.
+	mu      sync.Mutex
+	current int
+}
+
+func newChainAuth(cfg *Config) (*chainAuth, error) {
+	chain := &chainAuth{current: -1}
+	for _, authType := range cfg.AuthChain {
+		method, err := newAuthMethod(cfg, authType)
+		if err != nil {
+			return nil, err
+		}
+		chain.authTypes = append(chain.authTypes, authType)
+		chain.methods = append(chain.methods, method)
+	}
+	return chain, nil
+}
+
+func (c *chainAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	chainErr := &AuthChainError{}
+	for i, method := range c.methods {
+		secret, err := method.Login(ctx, client)
+		if err == nil {
+			c.mu.Lock()
+			c.current = i
+			c.mu.Unlock()
+			return secret, nil
+		}
+		chainErr.Attempts = append(chainErr.Attempts, AuthAttempt{
+			AuthType: c.authTypes[i],
+			Err:      err,
+		})
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, chainErr
+}
+
+// CacheIdentity joins the identities of the methods in the chain, leaving
+// out those whose tokens are borrowed. It is empty if any other method has no
+// identity.
+func (c *chainAuth) CacheIdentity() string {
+	var identities []string
+	for _, method := range c.methods {
+		if _, ok := method.(tokenBorrower); ok {
+			continue
+		}
+		identifier, ok := method.(CacheIdentifier)
+		if !ok {
+			return ""
+		}
+		identity := identifier.CacheIdentity()
+		if identity == "" {
+			return ""
+		}
+		identities = append(identities, identity)
+	}
+	return strings.Join(identities, ",")
+}
+
+// resolveCacheIdentity resolves the identity of every method of the chain
+// that needs resolving.
+func (c *chainAuth) resolveCacheIdentity(ctx context.Context) error {
+	for _, method := range c.methods {
+		if resolver, ok := method.(cacheIdentityResolver); ok {
+			if err := resolver.resolveCacheIdentity(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *chainAuth) borrowed(token string) bool {
+	for _, method := range c.methods {
+		if borrower, ok := method.(tokenBorrower); ok && borrower.borrowed(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch watches every method of the chain that is a tokenWatcher, passing on
+// what the one that last logged in reports.
+func (c *chainAuth) watch(ctx context.Context, changed func(), expiring func(error)) {
+	var wg sync.WaitGroup
+	for i, method := range c.methods {
+		watcher, ok := method.(tokenWatcher)
+		if !ok {
+			continue
+		}
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watcher.watch(ctx, func() {
+				if c.isCurrent(i) {
+					changed()
+				}
+			}, func(err error) {
+				if c.isCurrent(i) {
+					expiring(err)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// isCurrent reports whether the method at index i last logged in.
+func (c *chainAuth) isCurrent(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current == i
+}
@@ -1,10 +1,18 @@
 package vaultclient
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/hashicorp/vault/api"
 )
 
@@ -15,33 +23,103 @@ const (
 	Iam
 	AppRole
 	K8s
+	Userpass
+	Ldap
+	Cert
+	Jwt
+	Gcp
+	Azure
+	Ec2
+	AgentSink
 	EnvVarAwsRegion    = "AWS_REGION"
 	EnvVarStsAwsRegion = "STS_AWS_REGION"
 )
 
 type k8sAuth struct {
-	client *api.Client
-	role   string
-	path   string
-	auth   *Auth
+	role      string
+	path      string
+	tokenPath string
+	audience  string
 }
 
 type iamAuth struct {
-	role   string
-	client *api.Client
-	auth   *Auth
+	role           string
+	path           string
+	serverIdHeader string
+	// resolvers resolve the STS endpoints to sign the login request for, in
+	// the order they are tried.
+	resolvers []endpoints.ResolverFunc
+
+	source                 *credentials.Credentials
+	webIdentityRoleArn     string
+	webIdentityTokenFile   string
+	webIdentitySessionName string
+	assumeRoles            []IamAssumeRole
+	stsEndpoint            string
+
+	// creds are the credentials that sign the login request, built on the
+	// first login and kept so that the SDK can cache and refresh them.
+	// sourceAccessKeyId is the access key of source when it was last
+	// retrieved.
+	mu                sync.Mutex
+	creds             *credentials.Credentials
+	sourceAccessKeyId string
 }
 
 type tokenAuth struct {
-	client *api.Client
+	token string
+
+	// looked is closed once the token has first been looked up.
+	looked     chan struct{}
+	lookedOnce sync.Once
+
+	// what the last lookup or renewal found out about the token. A zero
+	// expiry never expires.
+	mu        sync.Mutex
+	accessor  string
+	renewable bool
+	expiry    time.Time
+	lifetime  time.Duration
+}
+
+type userpassAuth struct {
+	username string
+	password string
+	path     string
+}
+
+type ldapAuth struct {
+	username string
+	password SecretSource
+	path     string
+}
+
+type jwtAuth struct {
+	role string
+	jwt  SecretSource
+	path string
 }
 
 type appRoleAuth struct {
-	auth     *Auth
-	client   *api.Client
-	role     string
-	roleId   string
+	role   string
+	roleId string
+	path   string
+
+	secretIdFile    string
+	secretIdWrapped bool
+
+	// bootstrapToken, when set, mints the secret_id rather than it being
+	// given (pull mode).
+	bootstrapToken   SecretSource
+	secretIdMetadata map[string]string
+	secretIdCidrs    []string
+
+	mu       sync.Mutex
 	secretId string
+	// secretIdExpiry and secretIdUses are what is left of a minted secret_id,
+	// where Vault reports them. A zero expiry or zero uses is unlimited.
+	secretIdExpiry time.Time
+	secretIdUses   int
 }
 
 type Config struct {
@@ -54,6 +132,163 @@ type Config struct {
 	AppRoleSecretId string
 	K8sRole         string
 	K8sPath         string
+
+	// K8sTokenPath is the service account token to log in with for K8s auth,
+	// the token mounted by the service account admission controller when
+	// empty. K8sAudience, when set, is the audience a projected token must be
+	// bound to.
+	K8sTokenPath string
+	K8sAudience  string
+
+	// AppRoleSecretIdFile is read for the AppRole secret_id when
+	// AppRoleSecretId is empty. With AppRoleSecretIdWrapped set the file holds
+	// a response-wrapping token for the secret_id instead, which is unwrapped.
+	// Either way the secret_id is kept in memory and the file is removed once
+	// it has been read.
+	AppRoleSecretIdFile    string
+	AppRoleSecretIdWrapped bool
+
+	// AppRolePath is the mount of the AppRole auth backend, "approle" when
+	// empty.
+	AppRolePath string
+
+	// AppRoleBootstrapToken puts AppRole auth in pull mode. The token, which
+	// only needs to read the role_id, generate secret_ids of AppRole and look
+	// them up, is used to fetch the role_id (unless AppRoleId is set) and to
+	// mint a fresh secret_id, minting another once its secret_id_ttl or
+	// secret_id_num_uses runs out. Minted secret_ids carry
+	// AppRoleSecretIdMetadata and are bound to AppRoleSecretIdCidrs.
+	AppRoleBootstrapToken   SecretSource
+	AppRoleSecretIdMetadata map[string]string
+	AppRoleSecretIdCidrs    []string
+
+	// UserpassUsername and UserpassPassword are the credentials used to log
+	// in with Userpass auth, against the auth backend mounted at UserpassPath
+	// ("userpass" when empty).
+	UserpassUsername string
+	UserpassPassword string
+	UserpassPath     string
+
+	// LdapUsername is the user to log in as with Ldap auth, with the password
+	// read from LdapPassword on every login. LdapPath is the mount of the auth
+	// backend, "ldap" when empty.
+	LdapUsername string
+	LdapPassword SecretSource
+	LdapPath     string
+
+	// CertRole optionally names the certificate role to log in against with
+	// Cert auth, and CertPath is the mount of the auth backend, "cert" when
+	// empty. The client certificate is the one given to ConfigureTLS, or
+	// VAULT_CLIENT_CERT and VAULT_CLIENT_KEY.
+	CertRole string
+	CertPath string
+
+	// JwtRole is the role to log in as with Jwt auth, exchanging the JWT
+	// returned by Jwt on every login. JwtPath is the mount of the auth
+	// backend, "jwt" when empty.
+	JwtRole string
+	Jwt     SecretSource
+	JwtPath string
+
+	// GcpRole is the role to log in as with Gcp auth. GcpRoleType is either
+	// GcpRoleTypeIam, signing a JWT with the service account key in
+	// GcpCredentialsFile (GOOGLE_APPLICATION_CREDENTIALS when empty), or
+	// GcpRoleTypeGce, using the instance identity token from the metadata
+	// server at GcpMetadataEndpoint. When empty it is iam if a credentials
+	// file is available and gce otherwise. GcpPath is the mount of the auth
+	// backend, "gcp" when empty.
+	GcpRole             string
+	GcpRoleType         string
+	GcpPath             string
+	GcpCredentialsFile  string
+	GcpMetadataEndpoint string
+
+	// AzureRole is the role to log in as with Azure auth, using a managed
+	// identity token for AzureResource (the Azure Resource Manager when empty)
+	// and the instance metadata from IMDS at AzureImdsEndpoint. AzureClientId
+	// picks a user assigned identity. AzurePath is the mount of the auth
+	// backend, "azure" when empty.
+	AzureRole         string
+	AzurePath         string
+	AzureResource     string
+	AzureClientId     string
+	AzureImdsEndpoint string
+
+	// AwsPath is the mount of the AWS auth backend used by Iam and Ec2 auth,
+	// "aws" when empty. IamServerIdHeader, when set, is sent as the
+	// X-Vault-AWS-IAM-Server-ID header of the signed STS request for Iam
+	// auth, and must match the iam_server_id_header_value of the backend.
+	AwsPath           string
+	IamServerIdHeader string
+
+	// IamEndpointStrategy picks the STS endpoints Iam auth signs its login
+	// request for: IamEndpointRegionalThenGlobal when empty,
+	// IamEndpointRegional or IamEndpointGlobal.
+	IamEndpointStrategy string
+
+	// IamCredentials, or else IamCredentialsProvider, are the AWS credentials
+	// Iam auth starts from instead of the default credential chain.
+	// IamWebIdentityTokenFile and IamWebIdentityRoleArn start from the role
+	// assumed with a web identity token, such as an EKS service account token,
+	// instead. Each of IamAssumeRoles is then assumed in turn with the
+	// credentials of the one before, and the last signs the login request.
+	// Roles are assumed through IamStsEndpoint when set, such as an STS VPC
+	// endpoint, and through the STS endpoint of the AWS region otherwise.
+	IamCredentials            *credentials.Credentials
+	IamCredentialsProvider    credentials.Provider
+	IamWebIdentityRoleArn     string
+	IamWebIdentityTokenFile   string
+	IamWebIdentitySessionName string
+	IamAssumeRoles            []IamAssumeRole
+	IamStsEndpoint            string
+
+	// Ec2Role is the role to log in as with Ec2 auth, the ec2 flavour of the
+	// AWS auth backend, using the identity document from IMDS at
+	// Ec2ImdsEndpoint. The client nonce needed to log in again is kept in
+	// Ec2NonceFile so that it survives restarts; when empty it only lasts as
+	// long as the process.
+	Ec2Role         string
+	Ec2NonceFile    string
+	Ec2ImdsEndpoint string
+
+	// AgentSinkPath is the Vault Agent file sink to take the token from with
+	// AgentSink auth. The file is watched so that a token written by the agent
+	// is swapped in straight away. Set AgentSinkWrapped when the sink is
+	// response-wrapped.
+	AgentSinkPath    string
+	AgentSinkWrapped bool
+
+	// AuthChain lists auth methods to try in order on every login, falling
+	// through to the next one when a login fails. When set it is used instead
+	// of AuthType, and the settings for each listed method must be filled in.
+	AuthChain []AuthType
+
+	// BackgroundRenewal keeps login based tokens alive from a background
+	// goroutine instead of logging in again when VaultClient finds the token
+	// close to expiry. Call Close on the VaultAuth to stop it. Tokens that
+	// are not obtained by logging in, such as Config.Token or a Vault Agent
+	// sink token, are left to whoever owns them.
+	BackgroundRenewal bool
+
+	// LoginRetry retries logins that fail for a transient reason. When nil a
+	// failed login is reported straight away. Retries stack with those of the
+	// Vault client, which retries connection errors and 5xx responses up to
+	// MaxRetries times within each attempt, so a login can make up to
+	// (MaxRetries+1) × LoginRetry.MaxAttempts requests. Set MaxRetries to 0 to
+	// leave retrying to LoginRetry alone.
+	LoginRetry *RetryPolicy
+
+	// TokenCachePath, when set, keeps the token obtained by logging in in a
+	// file only readable by the current user, so that short lived processes
+	// can reuse it rather than logging in every time. The file is encrypted
+	// with a key derived from TokenCacheKey with salted PBKDF2, and is only
+	// written when the token changes. NewVaultAuth fails if TokenCacheKey
+	// is not set or cannot be read.
+	TokenCachePath string
+	TokenCacheKey  TokenCacheKeySource
+
+	clientCert string
+	clientKey  string
 }
 
 type Auth struct {
@@ -63,13 +298,36 @@ type Auth struct {
 
 var (
 	expirationWindow = time.Second * 10
+	revokeTimeout    = time.Second * 10
+
+	// ErrClosed is returned when a client is requested from a VaultAuth that
+	// has been closed.
+	ErrClosed = errors.New("vault auth has been closed")
 )
 
+// VaultAuth hands out a Vault client holding a valid token. Implementations
+// are safe for concurrent use.
 type VaultAuth interface {
 	VaultClient() (*api.Client, error)
 	VaultClientOrPanic() *api.Client
 }
 
+// VaultAuthContext is a VaultAuth whose logins can be bounded by a context.
+// The VaultAuth returned by NewVaultAuth implements it.
+type VaultAuthContext interface {
+	VaultAuth
+	VaultClientContext(ctx context.Context) (*api.Client, error)
+}
+
+// ClosableVaultAuth is a VaultAuth that revokes its token when closed. The
+// VaultAuth returned by NewVaultAuth implements it, and must be closed once it
+// is no longer needed to stop renewing its token in the background, even when
+// the token is a borrowed one that Close does not revoke.
+type ClosableVaultAuth interface {
+	VaultAuth
+	Close(ctx context.Context) error
+}
+
 func BaseConfig() *Config {
 	apiConfig := api.DefaultConfig()
 
@@ -80,17 +338,61 @@ func BaseConfig() *Config {
 	return config
 }
 
+// ConfigureTLS configures TLS on the embedded api.Config, keeping track of the
+// client certificate files so that Cert auth can reload them before logging in.
+func (c *Config) ConfigureTLS(t *api.TLSConfig) error {
+	if err := c.Config.ConfigureTLS(t); err != nil {
+		return err
+	}
+	if t.ClientCert != "" && t.ClientKey != "" {
+		c.clientCert = t.ClientCert
+		c.clientKey = t.ClientKey
+	}
+	return nil
+}
+
 func NewDefaultConfig() *Config {
 	config := BaseConfig()
 
+	if tokenCachePath := os.Getenv("VAULT_TOKEN_CACHE_PATH"); tokenCachePath != "" {
+		config.TokenCachePath = tokenCachePath
+		config.TokenCacheKey = TokenCacheKeyFromEnv("VAULT_TOKEN_CACHE_KEY")
+		if keyFile := os.Getenv("VAULT_TOKEN_CACHE_KEY_FILE"); keyFile != "" {
+			config.TokenCacheKey = TokenCacheKeyFromFile(keyFile)
+		} else if os.Getenv("VAULT_TOKEN_CACHE_KEY") == "" {
+			config.Error = fmt.Errorf("VAULT_TOKEN_CACHE_PATH is set without VAULT_TOKEN_CACHE_KEY or VAULT_TOKEN_CACHE_KEY_FILE")
+			return config
+		}
+	}
+
 	appRoleName := os.Getenv("VAULT_APP_ROLE")
 	appRoleId := os.Getenv("VAULT_APP_ROLE_ID")
 	appRoleSecretId := os.Getenv("VAULT_APP_SECRET_ID")
-	if appRoleId != "" && appRoleSecretId != "" && appRoleName != "" {
+	appRoleSecretIdFile := os.Getenv("VAULT_APP_SECRET_ID_FILE")
+	if appRoleId != "" && (appRoleSecretId != "" || appRoleSecretIdFile != "") && appRoleName != "" {
 		config.AuthType = AppRole
 		config.AppRole = appRoleName
 		config.AppRoleId = appRoleId
 		config.AppRoleSecretId = appRoleSecretId
+		config.AppRoleSecretIdFile = appRoleSecretIdFile
+		config.AppRoleSecretIdWrapped = os.Getenv("VAULT_APP_SECRET_ID_WRAPPED") == "true"
+		config.AppRolePath = os.Getenv("VAULT_APP_ROLE_PATH")
+
+		return config
+	}
+
+	var appRoleBootstrapToken SecretSource
+	if tokenFile := os.Getenv("VAULT_APP_ROLE_BOOTSTRAP_TOKEN_FILE"); tokenFile != "" {
+		appRoleBootstrapToken = SecretFromFile(tokenFile)
+	} else if os.Getenv("VAULT_APP_ROLE_BOOTSTRAP_TOKEN") != "" {
+		appRoleBootstrapToken = SecretFromEnv("VAULT_APP_ROLE_BOOTSTRAP_TOKEN")
+	}
+	if appRoleName != "" && appRoleBootstrapToken != nil {
+		config.AuthType = AppRole
+		config.AppRole = appRoleName
+		config.AppRoleId = appRoleId
+		config.AppRoleBootstrapToken = appRoleBootstrapToken
+		config.AppRolePath = os.Getenv("VAULT_APP_ROLE_PATH")
 
 		return config
 	}
@@ -99,6 +401,9 @@ func NewDefaultConfig() *Config {
 	if role != "" {
 		config.AuthType = Iam
 		config.IamRole = role
+		config.AwsPath = os.Getenv("VAULT_AWS_PATH")
+		config.IamServerIdHeader = os.Getenv("VAULT_IAM_SERVER_ID_HEADER")
+		config.IamEndpointStrategy = os.Getenv("VAULT_IAM_ENDPOINT_STRATEGY")
 
 		return config
 	}
@@ -112,6 +417,91 @@ func NewDefaultConfig() *Config {
 			k8sPath = fmt.Sprintf("k8s-%s", k8sRole)
 		}
 		config.K8sPath = k8sPath
+		config.K8sTokenPath = os.Getenv("K8S_TOKEN_PATH")
+		config.K8sAudience = os.Getenv("K8S_AUDIENCE")
+
+		return config
+	}
+
+	userpassUsername := os.Getenv("VAULT_USERPASS_USERNAME")
+	userpassPassword := os.Getenv("VAULT_USERPASS_PASSWORD")
+	if userpassUsername != "" && userpassPassword != "" {
+		config.AuthType = Userpass
+		config.UserpassUsername = userpassUsername
+		config.UserpassPassword = userpassPassword
+		config.UserpassPath = os.Getenv("VAULT_USERPASS_PATH")
+
+		return config
+	}
+
+	ldapUsername := os.Getenv("VAULT_LDAP_USERNAME")
+	var ldapPassword SecretSource
+	if passwordFile := os.Getenv("VAULT_LDAP_PASSWORD_FILE"); passwordFile != "" {
+		ldapPassword = SecretFromFile(passwordFile)
+	} else if os.Getenv("VAULT_LDAP_PASSWORD") != "" {
+		ldapPassword = SecretFromEnv("VAULT_LDAP_PASSWORD")
+	}
+	if ldapUsername != "" && ldapPassword != nil {
+		config.AuthType = Ldap
+		config.LdapUsername = ldapUsername
+		config.LdapPassword = ldapPassword
+		config.LdapPath = os.Getenv("VAULT_LDAP_PATH")
+
+		return config
+	}
+
+	jwtRole := os.Getenv("VAULT_JWT_ROLE")
+	var jwt SecretSource
+	if jwtFile := os.Getenv("VAULT_JWT_FILE"); jwtFile != "" {
+		jwt = SecretFromFile(jwtFile)
+	} else if os.Getenv("VAULT_JWT") != "" {
+		jwt = SecretFromEnv("VAULT_JWT")
+	}
+	if jwtRole != "" && jwt != nil {
+		config.AuthType = Jwt
+		config.JwtRole = jwtRole
+		config.Jwt = jwt
+		config.JwtPath = os.Getenv("VAULT_JWT_PATH")
+
+		return config
+	}
+
+	gcpRole := os.Getenv("VAULT_GCP_ROLE")
+	if gcpRole != "" {
+		config.AuthType = Gcp
+		config.GcpRole = gcpRole
+		config.GcpRoleType = os.Getenv("VAULT_GCP_ROLE_TYPE")
+		config.GcpPath = os.Getenv("VAULT_GCP_PATH")
+
+		return config
+	}
+
+	azureRole := os.Getenv("VAULT_AZURE_ROLE")
+	if azureRole != "" {
+		config.AuthType = Azure
+		config.AzureRole = azureRole
+		config.AzurePath = os.Getenv("VAULT_AZURE_PATH")
+		config.AzureResource = os.Getenv("VAULT_AZURE_RESOURCE")
+		config.AzureClientId = os.Getenv("AZURE_CLIENT_ID")
+
+		return config
+	}
+
+	ec2Role := os.Getenv("VAULT_EC2_ROLE")
+	if ec2Role != "" {
+		config.AuthType = Ec2
+		config.Ec2Role = ec2Role
+		config.Ec2NonceFile = os.Getenv("VAULT_EC2_NONCE_FILE")
+		config.AwsPath = os.Getenv("VAULT_AWS_PATH")
+
+		return config
+	}
+
+	agentSinkPath := os.Getenv("VAULT_AGENT_SINK_PATH")
+	if agentSinkPath != "" {
+		config.AuthType = AgentSink
+		config.AgentSinkPath = agentSinkPath
+		config.AgentSinkWrapped = os.Getenv("VAULT_AGENT_SINK_WRAPPED") == "true"
 
 		return config
 	}
@@ -129,38 +519,69 @@ func NewDefaultConfig() *Config {
 }
 
 func NewVaultAuth(cfg *Config) (VaultAuth, error) {
+	var method AuthMethod
+	var err error
+	if len(cfg.AuthChain) > 0 {
+		method, err = newChainAuth(cfg)
+	} else {
+		method, err = newAuthMethod(cfg, cfg.AuthType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := newVaultAuthWithMethod(cfg, method)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewVaultAuthWithMethod returns a VaultAuth that logs in with method,
+// ignoring the AuthType and AuthChain of cfg.
+func NewVaultAuthWithMethod(cfg *Config, method AuthMethod) (VaultAuth, error) {
+	v, err := newVaultAuthWithMethod(cfg, method)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func newVaultAuthWithMethod(cfg *Config, method AuthMethod) (*tokenManager, error) {
 	c, err := api.NewClient(cfg.Config)
 	if err != nil {
 		return nil, err
 	}
+	return newTokenManager(c, method, cfg)
+}
 
-	switch cfg.AuthType {
+func (t AuthType) String() string {
+	switch t {
 	case Token:
-		c.SetToken(cfg.Token)
-		return &tokenAuth{
-			client: c,
-		}, nil
-	case AppRole:
-		return &appRoleAuth{
-			client:   c,
-			role:     cfg.AppRole,
-			secretId: cfg.AppRoleSecretId,
-			roleId:   cfg.AppRoleId,
-		}, nil
+		return "token"
 	case Iam:
-		return &iamAuth{
-			client: c,
-			role:   cfg.IamRole,
-		}, nil
+		return "iam"
+	case AppRole:
+		return "approle"
 	case K8s:
-		return &k8sAuth{
-			client: c,
-			role:   cfg.K8sRole,
-			path:   cfg.K8sPath,
-		}, nil
-
+		return "k8s"
+	case Userpass:
+		return "userpass"
+	case Ldap:
+		return "ldap"
+	case Cert:
+		return "cert"
+	case Jwt:
+		return "jwt"
+	case Gcp:
+		return "gcp"
+	case Azure:
+		return "azure"
+	case Ec2:
+		return "ec2"
+	case AgentSink:
+		return "agentsink"
 	}
-	return nil, fmt.Errorf("unknown auth type '%d'", cfg.AuthType)
+	return fmt.Sprintf("AuthType(%d)", int(t))
 }
 
 func (v *Auth) IsTokenExpired() bool {
@@ -171,58 +592,120 @@ func (v *Auth) IsTokenExpired() bool {
 	return v.expiry.Before(time.Now().Add(expirationWindow).UTC())
 }
 
-func (t *tokenAuth) VaultClient() (*api.Client, error) {
-	return t.client, nil
+func newAppRoleAuth(cfg *Config) (AuthMethod, error) {
+	a := &appRoleAuth{
+		role:             cfg.AppRole,
+		secretId:         cfg.AppRoleSecretId,
+		roleId:           cfg.AppRoleId,
+		path:             cfg.AppRolePath,
+		secretIdFile:     cfg.AppRoleSecretIdFile,
+		secretIdWrapped:  cfg.AppRoleSecretIdWrapped,
+		bootstrapToken:   cfg.AppRoleBootstrapToken,
+		secretIdMetadata: cfg.AppRoleSecretIdMetadata,
+		secretIdCidrs:    cfg.AppRoleSecretIdCidrs,
+	}
+	if a.path == "" {
+		a.path = defaultAppRolePath
+	}
+	if a.bootstrapToken != nil {
+		if a.role == "" {
+			return nil, errors.New("app role pull mode requires the name of the role")
+		}
+		if a.secretId != "" || a.secretIdFile != "" {
+			return nil, errors.New("app role pull mode mints its own secret id")
+		}
+	}
+	return a, nil
 }
 
-func (t *tokenAuth) VaultClientOrPanic() *api.Client {
-	client, err := t.VaultClient()
+func (a *appRoleAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if a.bootstrapToken != nil {
+		return a.pullLogin(ctx, client)
+	}
+
+	secretId, err := a.loadSecretId(ctx, client)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return client
+	return a.login(ctx, client, a.roleId, secretId)
 }
 
-func (a *appRoleAuth) getAuth() (*Auth, error) {
+// CacheIdentity is the role_id the secret_id was given for or, in pull mode,
+// the role it is fetched for.
+func (a *appRoleAuth) CacheIdentity() string {
+	if a.bootstrapToken != nil {
+		return cacheIdentity(AppRole, a.path, a.role)
+	}
+	return cacheIdentity(AppRole, a.path, a.role, a.roleId)
+}
+
+func (a *appRoleAuth) login(ctx context.Context, client *api.Client, roleId, secretId string) (*api.Secret, error) {
 	data := map[string]interface{}{
-		"role_id":   a.roleId,
-		"secret_id": a.secretId,
+		"role_id":   roleId,
+		"secret_id": secretId,
 	}
 
-	resp, err := a.client.Logical().Write("auth/approle/login", data)
-	if err != nil {
-		return nil, err
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", a.path), data)
+}
+
+// loadSecretId returns the secret_id, reading it from the secret_id file the
+// first time round. The file is removed once read, as a wrapped secret_id can
+// only be unwrapped once and a plain one should not linger on disk.
+func (a *appRoleAuth) loadSecretId(ctx context.Context, client *api.Client) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.secretId != "" || a.secretIdFile == "" {
+		return a.secretId, nil
 	}
 
-	tokenTtl, err := resp.TokenTTL()
+	data, err := ioutil.ReadFile(a.secretIdFile)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("reading app role secret id: %w", err)
+	}
+	secretId := strings.TrimSpace(string(data))
+	if a.secretIdWrapped {
+		if secretId, err = unwrapSecretId(ctx, client, secretId); err != nil {
+			return "", err
+		}
+	}
+	if secretId == "" {
+		return "", errors.New("app role secret id file is empty")
 	}
 
-	return &Auth{
-		token:  resp.Auth.ClientToken,
-		expiry: time.Now().UTC().Add(tokenTtl),
-	}, nil
+	a.secretId = secretId
+	removeSecretFile(a.secretIdFile, len(data))
+	return a.secretId, nil
 }
 
-func (a *appRoleAuth) VaultClient() (*api.Client, error) {
-	if !a.auth.IsTokenExpired() {
-		return a.client, nil
+// unwrapSecretId unwraps the secret_id wrapped in wrapped, which is either
+// the wrapping token or the wrap info returned alongside it as JSON.
+func unwrapSecretId(ctx context.Context, client *api.Client, wrapped string) (string, error) {
+	wrappingToken := wrapped
+	if strings.HasPrefix(wrapped, "{") {
+		var wrapInfo api.SecretWrapInfo
+		if err := json.Unmarshal([]byte(wrapped), &wrapInfo); err != nil {
+			return "", fmt.Errorf("parsing wrapped app role secret id: %w", err)
+		}
+		wrappingToken = wrapInfo.Token
 	}
 
-	var err error
-	a.auth, err = a.getAuth()
+	secret, err := unwrapWithContext(ctx, client, wrappingToken)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("unwrapping app role secret id: %w", err)
+	}
+	if secret == nil {
+		return "", errors.New("no app role secret id found in wrapped response")
 	}
-	a.client.SetToken(a.auth.token)
-	return a.client, nil
+	secretId, _ := secret.Data["secret_id"].(string)
+	return secretId, nil
 }
 
-func (a *appRoleAuth) VaultClientOrPanic() *api.Client {
-	client, err := a.VaultClient()
-	if err != nil {
-		panic(err)
+// removeSecretFile removes a file holding a secret, overwriting it with
+// zeros instead if it cannot be removed.
+func removeSecretFile(path string, size int) {
+	if err := os.Remove(path); err == nil || os.IsNotExist(err) {
+		return
 	}
-	return client
+	_ = ioutil.WriteFile(path, make([]byte, size), 0600)
 }
@@ -0,0 +1,138 @@
+package vaultclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultEc2ImdsEndpoint = "http://169.254.169.254"
+
+	// ec2ImdsTokenTtl is how long the IMDSv2 session token is requested for.
+	// A new one is requested on every login.
+	ec2ImdsTokenTtl = "60"
+)
+
+// ec2Auth logs in with the ec2 flavour of the AWS auth backend, presenting
+// the PKCS7 signed identity document of the instance. The client nonce that
+// Vault requires to log in again from the same instance is kept in nonceFile.
+type ec2Auth struct {
+	role      string
+	path      string
+	nonceFile string
+
+	imdsEndpoint string
+	httpClient   *http.Client
+
+	mu    sync.Mutex
+	nonce string
+}
+
+func newEc2Auth(cfg *Config) (AuthMethod, error) {
+	e := &ec2Auth{
+		role:         cfg.Ec2Role,
+		path:         awsPath(cfg),
+		nonceFile:    cfg.Ec2NonceFile,
+		imdsEndpoint: cfg.Ec2ImdsEndpoint,
+		httpClient:   newMetadataClient(),
+	}
+	if e.imdsEndpoint == "" {
+		e.imdsEndpoint = defaultEc2ImdsEndpoint
+	}
+	e.imdsEndpoint = strings.TrimSuffix(e.imdsEndpoint, "/")
+	return e, nil
+}
+
+func (e *ec2Auth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	pkcs7, err := e.identityDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := e.clientNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role":  e.role,
+		"pkcs7": pkcs7,
+		"nonce": nonce,
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", e.path), data)
+}
+
+func (e *ec2Auth) CacheIdentity() string {
+	return cacheIdentity(Ec2, e.path, e.role)
+}
+
+// identityDocument fetches the PKCS7 signed identity document from IMDS,
+// using an IMDSv2 session token when IMDS hands one out and falling back to
+// IMDSv1 otherwise.
+func (e *ec2Auth) identityDocument(ctx context.Context) (string, error) {
+	header := http.Header{}
+	token, err := requestMetadata(ctx, e.httpClient, "PUT", e.imdsEndpoint+"/latest/api/token", http.Header{
+		"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {ec2ImdsTokenTtl},
+	})
+	if err == nil {
+		header.Set("X-Aws-Ec2-Metadata-Token", strings.TrimSpace(string(token)))
+	} else if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	pkcs7, err := getMetadata(ctx, e.httpClient, e.imdsEndpoint+"/latest/dynamic/instance-identity/pkcs7", header)
+	if err != nil {
+		return "", fmt.Errorf("fetching ec2 identity document: %w", err)
+	}
+	return strings.Replace(strings.TrimSpace(string(pkcs7)), "\n", "", -1), nil
+}
+
+// clientNonce returns the nonce to log in with, generating one and keeping
+// it in the nonce file the first time round.
+func (e *ec2Auth) clientNonce() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.nonce != "" {
+		return e.nonce, nil
+	}
+
+	if e.nonceFile != "" {
+		data, err := ioutil.ReadFile(e.nonceFile)
+		if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			e.nonce = strings.TrimSpace(string(data))
+			return e.nonce, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("reading ec2 nonce: %w", err)
+		}
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating ec2 nonce: %w", err)
+	}
+	encoded := hex.EncodeToString(nonce)
+
+	// the nonce is only kept once saved, so a failed save is retried on the
+	// next login rather than using a nonce the next process cannot know
+	if e.nonceFile != "" {
+		if err := os.MkdirAll(filepath.Dir(e.nonceFile), 0700); err != nil {
+			return "", fmt.Errorf("writing ec2 nonce: %w", err)
+		}
+		if err := ioutil.WriteFile(e.nonceFile, []byte(encoded), 0600); err != nil {
+			return "", fmt.Errorf("writing ec2 nonce: %w", err)
+		}
+	}
+	e.nonce = encoded
+	return e.nonce, nil
+}
@@ -0,0 +1,113 @@
+package vaultclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+type TokenEventType int
+
+const (
+	// TokenRotated is sent when a login has replaced the token.
+	TokenRotated TokenEventType = iota + 1
+	// TokenRenewed is sent when the TTL of the current token has been
+	// extended, by the background renewer or by Token auth renewing the token
+	// it was given.
+	TokenRenewed
+	// LoginFailed is sent when a login has failed. Err holds the reason.
+	LoginFailed
+	// TokenExpiring is sent when the token given for Token auth cannot be
+	// renewed and has less than a third of its lifetime left. Err holds a
+	// *TokenExpiringError.
+	TokenExpiring
+)
+
+// TokenEvent describes a change to the token held by a VaultAuth.
+type TokenEvent struct {
+	Type     TokenEventType
+	Accessor string
+	TTL      time.Duration
+	Expiry   time.Time
+	Err      error
+}
+
+// SubscribableVaultAuth is a VaultAuth that reports changes to its token.
+// The VaultAuth returned by NewVaultAuth implements it.
+type SubscribableVaultAuth interface {
+	VaultAuth
+	Subscribe(fn func(TokenEvent)) (unsubscribe func())
+}
+
+// notifier hands token events to the subscribed callbacks.
+type notifier struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]func(TokenEvent)
+}
+
+// Subscribe registers fn to be called with every token event, until the
+// returned func is called. fn is called from the goroutine that performed the
+// login or renewal, so it should return quickly.
+func (n *notifier) Subscribe(fn func(TokenEvent)) (unsubscribe func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.subs == nil {
+		n.subs = make(map[int]func(TokenEvent))
+	}
+	id := n.next
+	n.next++
+	n.subs[id] = fn
+
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.subs, id)
+	}
+}
+
+func (n *notifier) notify(event TokenEvent) {
+	n.mu.Lock()
+	subs := make([]func(TokenEvent), 0, len(n.subs))
+	for _, fn := range n.subs {
+		subs = append(subs, fn)
+	}
+	n.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(event)
+	}
+}
+
+func (n *notifier) notifyToken(eventType TokenEventType, secret *api.Secret, auth *Auth) {
+	event := TokenEvent{
+		Type:   eventType,
+		Expiry: auth.expiry,
+	}
+	event.TTL, _ = secret.TokenTTL()
+	if secret.Auth != nil {
+		event.Accessor = secret.Auth.Accessor
+	}
+	n.notify(event)
+}
+
+func (n *notifier) notifyTokenExpiring(err error) {
+	event := TokenEvent{
+		Type: TokenExpiring,
+		Err:  err,
+	}
+	if expiring, ok := err.(*TokenExpiringError); ok {
+		event.Accessor = expiring.Accessor
+		event.Expiry = expiring.Expiry
+		event.TTL = time.Until(expiring.Expiry)
+	}
+	n.notify(event)
+}
+
+func (n *notifier) notifyLoginFailed(err error) {
+	n.notify(TokenEvent{
+		Type: LoginFailed,
+		Err:  err,
+	})
+}
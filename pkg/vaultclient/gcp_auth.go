@@ -0,0 +1,198 @@
+package vaultclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// GcpRoleTypeIam and GcpRoleTypeGce are the role types of Gcp auth.
+	GcpRoleTypeIam = "iam"
+	GcpRoleTypeGce = "gce"
+
+	defaultGcpPath             = "gcp"
+	defaultGcpMetadataEndpoint = "http://metadata.google.internal"
+
+	// gcpJwtExpiry is how long the JWT signed for iam logins is valid for,
+	// inside the 15 minute limit Vault accepts by default.
+	gcpJwtExpiry = time.Minute * 10
+)
+
+type gcpAuth struct {
+	role     string
+	roleType string
+	path     string
+
+	// credentialsFile is the service account key used to sign iam logins.
+	credentialsFile string
+	// metadataEndpoint is where the identity token for gce logins is fetched.
+	metadataEndpoint string
+	httpClient       *http.Client
+}
+
+// gcpServiceAccountKey is the part of a service account key file needed to
+// sign a JWT.
+type gcpServiceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyId string `json:"private_key_id"`
+}
+
+func newGcpAuth(cfg *Config) (AuthMethod, error) {
+	g := &gcpAuth{
+		role:             cfg.GcpRole,
+		roleType:         cfg.GcpRoleType,
+		path:             cfg.GcpPath,
+		credentialsFile:  cfg.GcpCredentialsFile,
+		metadataEndpoint: cfg.GcpMetadataEndpoint,
+		httpClient:       newMetadataClient(),
+	}
+	if g.path == "" {
+		g.path = defaultGcpPath
+	}
+	if g.credentialsFile == "" {
+		g.credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if g.metadataEndpoint == "" {
+		if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+			g.metadataEndpoint = "http://" + host
+		} else {
+			g.metadataEndpoint = defaultGcpMetadataEndpoint
+		}
+	}
+	if g.roleType == "" {
+		g.roleType = GcpRoleTypeGce
+		if g.credentialsFile != "" {
+			g.roleType = GcpRoleTypeIam
+		}
+	}
+
+	switch g.roleType {
+	case GcpRoleTypeIam:
+		if g.credentialsFile == "" {
+			return nil, errors.New("gcp iam auth requires a service account credentials file")
+		}
+	case GcpRoleTypeGce:
+	default:
+		return nil, fmt.Errorf("unknown gcp role type '%s'", g.roleType)
+	}
+	return g, nil
+}
+
+func (g *gcpAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	var jwt string
+	var err error
+	if g.roleType == GcpRoleTypeIam {
+		jwt, err = g.signedJwt()
+	} else {
+		jwt, err = g.identityToken(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"role": g.role,
+		"jwt":  jwt,
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", g.path), data)
+}
+
+func (g *gcpAuth) CacheIdentity() string {
+	return cacheIdentity(Gcp, g.path, g.role, g.roleType, g.credentialsFile)
+}
+
+// signedJwt signs a JWT for the role with the service account key, the way
+// the IAM credentials signJwt API would.
+func (g *gcpAuth) signedJwt() (string, error) {
+	data, err := ioutil.ReadFile(g.credentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("reading gcp credentials: %w", err)
+	}
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return "", fmt.Errorf("parsing gcp credentials: %w", err)
+	}
+	privateKey, err := parseRsaPrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("parsing gcp credentials: %w", err)
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": key.PrivateKeyId,
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"sub": key.ClientEmail,
+		"aud": fmt.Sprintf("vault/%s", g.role),
+		"exp": time.Now().Add(gcpJwtExpiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing gcp jwt: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// identityToken fetches an instance identity token for the role from the
+// metadata server.
+func (g *gcpAuth) identityToken(ctx context.Context) (string, error) {
+	query := url.Values{
+		"audience": {fmt.Sprintf("http://vault/%s", g.role)},
+		"format":   {"full"},
+	}
+	endpoint := fmt.Sprintf("%s/computeMetadata/v1/instance/service-accounts/default/identity?%s",
+		strings.TrimSuffix(g.metadataEndpoint, "/"), query.Encode())
+
+	body, err := getMetadata(ctx, g.httpClient, endpoint, http.Header{"Metadata-Flavor": {"Google"}})
+	if err != nil {
+		return "", fmt.Errorf("fetching gce identity token: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func parseRsaPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no pem encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an rsa key")
+	}
+	return rsaKey, nil
+}
@@ -1,15 +1,18 @@
 package vaultclient
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
@@ -17,79 +20,282 @@ import (
 	"github.com/hashicorp/vault/sdk/helper/awsutil"
 )
 
-func (v *iamAuth) VaultClientOrPanic() *api.Client {
-	client, err := v.VaultClient()
-	if err != nil {
-		panic(err)
+const (
+	defaultAwsPath = "aws"
+
+	// iamServerIdHeader is the header Vault checks against
+	// iam_server_id_header_value, to stop a signed request made for one Vault
+	// cluster being replayed against another.
+	iamServerIdHeader = "X-Vault-AWS-IAM-Server-ID"
+)
+
+const (
+	// IamEndpointRegionalThenGlobal signs the login request for the regional
+	// STS endpoint of STS_AWS_REGION, or for the endpoint the AWS SDK resolves
+	// by default when that is unset, signing it again for the global endpoint
+	// if that fails. IamEndpointRegional signs it for just the regional
+	// endpoint of STS_AWS_REGION, or of the AWS region when that is unset, and
+	// IamEndpointGlobal for just the global endpoint. The global endpoint is
+	// https://sts.amazonaws.com signed for us-east-1, whatever the AWS region
+	// or AWS_STS_REGIONAL_ENDPOINTS, outside of partitions that have none,
+	// such as China's.
+	IamEndpointRegionalThenGlobal = "regional-then-global"
+	IamEndpointRegional           = "regional"
+	IamEndpointGlobal             = "global"
+)
+
+// IamEndpointAttempt is the outcome of logging in with a request signed for
+// one STS endpoint.
+type IamEndpointAttempt struct {
+	Endpoint      string
+	SigningRegion string
+	Err           error
+}
+
+// IamLoginError is returned when Iam auth failed to log in with a request
+// signed for every STS endpoint its endpoint strategy allows. It holds the
+// error of each attempt, in order.
+type IamLoginError struct {
+	Attempts []IamEndpointAttempt
+}
+
+func (e *IamLoginError) Error() string {
+	attempts := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		attempts = append(attempts, fmt.Sprintf("%s (signed for %s): %s", attempt.Endpoint, attempt.SigningRegion, attempt.Err))
 	}
-	return client
+	return fmt.Sprintf("iam login failed for every sts endpoint: %s", strings.Join(attempts, "; "))
 }
 
-func (v *iamAuth) VaultClient() (*api.Client, error) {
-	if !v.auth.IsTokenExpired() {
-		return v.client, nil
+// IamAssumeRole is a role assumed by Iam auth on the way to the credentials
+// that sign its login request.
+type IamAssumeRole struct {
+	RoleArn string
+	// SessionName is the role session name, generated when empty.
+	SessionName string
+	ExternalId  string
+}
+
+func newIamAuth(cfg *Config) (AuthMethod, error) {
+	source := cfg.IamCredentials
+	if source == nil && cfg.IamCredentialsProvider != nil {
+		source = credentials.NewCredentials(cfg.IamCredentialsProvider)
 	}
-	var err error
-	v.auth, err = v.getAuth()
-	if err != nil {
-		return nil, err
+	if cfg.IamWebIdentityTokenFile != "" {
+		if source != nil {
+			return nil, errors.New("iam auth cannot start from both credentials and a web identity token")
+		}
+		if cfg.IamWebIdentityRoleArn == "" {
+			return nil, errors.New("iam auth with a web identity token requires the arn of the role to assume")
+		}
+	}
+	var resolvers []endpoints.ResolverFunc
+	switch cfg.IamEndpointStrategy {
+	case "", IamEndpointRegionalThenGlobal:
+		resolvers = []endpoints.ResolverFunc{endpointSigningResolver, globalEndpointSigningResolver}
+	case IamEndpointRegional:
+		resolvers = []endpoints.ResolverFunc{regionalEndpointSigningResolver}
+	case IamEndpointGlobal:
+		resolvers = []endpoints.ResolverFunc{globalEndpointSigningResolver}
+	default:
+		return nil, fmt.Errorf("unknown iam endpoint strategy '%s'", cfg.IamEndpointStrategy)
+	}
+	for _, role := range cfg.IamAssumeRoles {
+		if role.RoleArn == "" {
+			return nil, errors.New("iam auth requires the arn of every role to assume")
+		}
 	}
-	v.client.SetToken(v.auth.token)
-	return v.client, nil
+
+	return &iamAuth{
+		role:                   cfg.IamRole,
+		path:                   awsPath(cfg),
+		serverIdHeader:         cfg.IamServerIdHeader,
+		resolvers:              resolvers,
+		source:                 source,
+		webIdentityRoleArn:     cfg.IamWebIdentityRoleArn,
+		webIdentityTokenFile:   cfg.IamWebIdentityTokenFile,
+		webIdentitySessionName: cfg.IamWebIdentitySessionName,
+		assumeRoles:            cfg.IamAssumeRoles,
+		stsEndpoint:            cfg.IamStsEndpoint,
+	}, nil
 }
 
-func (v *iamAuth) getAuth() (*Auth, error) {
-	baseSession := session.Must(session.NewSession())
+func awsPath(cfg *Config) string {
+	if cfg.AwsPath == "" {
+		return defaultAwsPath
+	}
+	return cfg.AwsPath
+}
 
-	resp, err := v.loginWithFallback(baseSession)
+func (v *iamAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	if err := v.resolveCacheIdentity(ctx); err != nil {
+		return nil, err
+	}
+	creds, err := v.credentials()
 	if err != nil {
 		return nil, err
 	}
+	// fetch the credentials, and any roles they assume, while ctx lasts
+	if _, err := creds.GetWithContext(ctx); err != nil {
+		return nil, err
+	}
+	return v.loginWithResolvers(ctx, client, creds)
+}
 
-	tokenTtl, err := resp.TokenTTL()
+// CacheIdentity includes the access key of explicitly given credentials, so
+// that tokens of different AWS principals are not mixed up. It is empty until
+// the credentials have been retrieved by resolveCacheIdentity.
+func (v *iamAuth) CacheIdentity() string {
+	fields := []string{v.path, v.role, v.webIdentityRoleArn}
+	if v.source != nil {
+		v.mu.Lock()
+		accessKeyId := v.sourceAccessKeyId
+		v.mu.Unlock()
+		if accessKeyId == "" {
+			return ""
+		}
+		fields = append(fields, accessKeyId)
+	}
+	for _, role := range v.assumeRoles {
+		fields = append(fields, role.RoleArn)
+	}
+	return cacheIdentity(Iam, fields...)
+}
+
+// resolveCacheIdentity retrieves explicitly given credentials to learn their
+// access key, which may take a call to AWS.
+func (v *iamAuth) resolveCacheIdentity(ctx context.Context) error {
+	if v.source == nil {
+		return nil
+	}
+	creds, err := v.source.GetWithContext(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	v.mu.Lock()
+	v.sourceAccessKeyId = creds.AccessKeyID
+	v.mu.Unlock()
+	return nil
+}
 
-	return &Auth{
-		token:  resp.Auth.ClientToken,
-		expiry: time.Now().UTC().Add(tokenTtl),
-	}, nil
+// credentials returns the credentials that sign the login request, building
+// the chain from the source credentials through the roles to assume on the
+// first call.
+func (v *iamAuth) credentials() (*credentials.Credentials, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.creds != nil {
+		return v.creds, nil
+	}
+
+	creds := v.source
+	switch {
+	case v.webIdentityTokenFile != "":
+		stsSession, err := v.assumeRoleSession(credentials.AnonymousCredentials)
+		if err != nil {
+			return nil, err
+		}
+		creds = stscreds.NewWebIdentityCredentials(stsSession, v.webIdentityRoleArn, v.webIdentitySessionName, v.webIdentityTokenFile)
+	case creds == nil:
+		baseSession, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		creds = baseSession.Config.Credentials
+	}
+
+	for _, role := range v.assumeRoles {
+		stsSession, err := v.assumeRoleSession(creds)
+		if err != nil {
+			return nil, err
+		}
+		role := role
+		creds = stscreds.NewCredentials(stsSession, role.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			if role.SessionName != "" {
+				p.RoleSessionName = role.SessionName
+			}
+			if role.ExternalId != "" {
+				p.ExternalID = aws.String(role.ExternalId)
+			}
+		})
+	}
+
+	v.creds = creds
+	return creds, nil
 }
 
-func (v *iamAuth) login(session *session.Session) (*api.Secret, error) {
-	data, err := generateLoginData(session)
+// assumeRoleSession returns the session that assumes roles with creds,
+// through stsEndpoint when one is set.
+func (v *iamAuth) assumeRoleSession(creds *credentials.Credentials) (*session.Session, error) {
+	stsSession, err := CreateSession(creds, os.Getenv(EnvVarAwsRegion))
 	if err != nil {
 		return nil, err
 	}
-	data["role"] = v.role
-	return v.client.Logical().Write("auth/aws/login", data)
+	if v.stsEndpoint != "" {
+		stsSession = stsSession.Copy(&aws.Config{Endpoint: aws.String(v.stsEndpoint)})
+	}
+	return stsSession, nil
 }
 
-func (v *iamAuth) loginWithFallback(session *session.Session) (*api.Secret, error) {
-	creds := session.Config.Credentials
-	configuredRegion := os.Getenv(EnvVarAwsRegion)
-	stsSession, err := CreateSession(creds, configuredRegion)
+func (v *iamAuth) loginWithSts(ctx context.Context, client *api.Client, svc *sts.STS) (*api.Secret, error) {
+	data, err := generateLoginData(ctx, svc, v.serverIdHeader)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := v.login(stsSession)
-	if err != nil {
-		stsSession, err = createSessionWithResolver(creds, configuredRegion, fallbackEndpointSigningResolver)
+	data["role"] = v.role
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", v.path), data)
+}
+
+// loginWithResolvers logs in with a request signed for the STS endpoint of
+// each resolver in turn, until one succeeds. An endpoint already tried is
+// not tried again.
+func (v *iamAuth) loginWithResolvers(ctx context.Context, client *api.Client, creds *credentials.Credentials) (*api.Secret, error) {
+	configuredRegion := os.Getenv(EnvVarAwsRegion)
+	loginErr := &IamLoginError{}
+	for _, resolver := range v.resolvers {
+		stsSession, err := createSessionWithResolver(creds, configuredRegion, resolver)
 		if err != nil {
 			return nil, err
 		}
-		return v.login(stsSession)
+		svc := sts.New(stsSession)
+		if loginErr.attempted(svc.Endpoint, svc.SigningRegion) {
+			continue
+		}
+		secret, err := v.loginWithSts(ctx, client, svc)
+		if err == nil {
+			return secret, nil
+		}
+		loginErr.Attempts = append(loginErr.Attempts, IamEndpointAttempt{
+			Endpoint:      svc.Endpoint,
+			SigningRegion: svc.SigningRegion,
+			Err:           err,
+		})
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, loginErr
+}
+
+func (e *IamLoginError) attempted(endpoint, signingRegion string) bool {
+	for _, attempt := range e.Attempts {
+		if attempt.Endpoint == endpoint && attempt.SigningRegion == signingRegion {
+			return true
+		}
 	}
-	return resp, err
+	return false
 }
 
-func generateLoginData(stsSession *session.Session) (map[string]interface{}, error) {
+func generateLoginData(ctx context.Context, svc *sts.STS, serverIdHeader string) (map[string]interface{}, error) {
 	loginData := make(map[string]interface{})
 
 	var params *sts.GetCallerIdentityInput
-	svc := sts.New(stsSession)
 	stsRequest, _ := svc.GetCallerIdentityRequest(params)
+	stsRequest.SetContext(ctx)
+	if serverIdHeader != "" {
+		stsRequest.HTTPRequest.Header.Add(iamServerIdHeader, serverIdHeader)
+	}
 	err := stsRequest.Sign()
 	if err != nil {
 		return nil, err
@@ -115,7 +321,7 @@ func CreateSession(creds *credentials.Credentials, configuredRegion string) (*se
 	return createSessionWithResolver(creds, configuredRegion, endpointSigningResolver)
 }
 
-func createSessionWithResolver(creds *credentials.Credentials, configuredRegion string, resolver func(service string, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error)) (*session.Session, error) {
+func createSessionWithResolver(creds *credentials.Credentials, configuredRegion string, resolver endpoints.ResolverFunc) (*session.Session, error) {
 	region, err := awsutil.GetRegion(configuredRegion)
 	if err != nil {
 		return nil, err
@@ -124,14 +330,42 @@ func createSessionWithResolver(creds *credentials.Credentials, configuredRegion
 		Config: aws.Config{
 			Credentials:      creds,
 			Region:           &region,
-			EndpointResolver: endpoints.ResolverFunc(resolver),
+			EndpointResolver: resolver,
 		},
 	})
 	return s, err
 }
 
-func fallbackEndpointSigningResolver(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
-	return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+// globalEndpointSigningResolver resolves the global STS endpoint, which the
+// SDK itself only resolves for some regions, and not at all once
+// AWS_STS_REGIONAL_ENDPOINTS is regional. A region outside the aws partition
+// gets the endpoint the SDK resolves by default, as its partition has no
+// global endpoint.
+func globalEndpointSigningResolver(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	partition, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if service != sts.EndpointsID || partition.ID() != endpoints.AwsPartitionID {
+		return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+	}
+	return endpoints.ResolvedEndpoint{
+		URL:           "https://sts.amazonaws.com",
+		PartitionID:   endpoints.AwsPartitionID,
+		SigningRegion: endpoints.UsEast1RegionID,
+		SigningName:   service,
+		SigningMethod: "v4",
+	}, nil
+}
+
+// regionalEndpointSigningResolver resolves the regional STS endpoint of
+// STS_AWS_REGION or, when that is unset, of the session's region, rather than
+// the global endpoint the SDK resolves for most regions by default.
+func regionalEndpointSigningResolver(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	if _, present := os.LookupEnv(EnvVarStsAwsRegion); present {
+		return endpointSigningResolver(service, region, optFns...)
+	}
+	optFns = append(optFns, func(o *endpoints.Options) {
+		o.STSRegionalEndpoint = endpoints.RegionalSTSEndpoint
+	})
+	return endpointSigningResolver(service, region, optFns...)
 }
 
 func endpointSigningResolver(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
@@ -0,0 +1,42 @@
+package vaultclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultJwtPath = "jwt"
+
+func newJwtAuth(cfg *Config) (AuthMethod, error) {
+	if cfg.Jwt == nil {
+		return nil, errors.New("jwt auth requires a jwt source")
+	}
+	path := cfg.JwtPath
+	if path == "" {
+		path = defaultJwtPath
+	}
+	return &jwtAuth{
+		role: cfg.JwtRole,
+		jwt:  cfg.Jwt,
+		path: path,
+	}, nil
+}
+
+func (j *jwtAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	jwt, err := j.jwt()
+	if err != nil {
+		return nil, fmt.Errorf("reading jwt: %w", err)
+	}
+	data := map[string]interface{}{
+		"jwt":  jwt,
+		"role": j.role,
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", j.path), data)
+}
+
+func (j *jwtAuth) CacheIdentity() string {
+	return cacheIdentity(Jwt, j.path, j.role)
+}
@@ -1,60 +1,95 @@
 package vaultclient
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"github.com/hashicorp/vault/api"
 	"io/ioutil"
-	"time"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
 )
 
-func (k *k8sAuth) VaultClientOrPanic() *api.Client {
-	client, err := k.VaultClient()
-	if err != nil {
-		panic(err)
+// defaultK8sTokenPath comes from https://kubernetes.io/docs/reference/access-authn-authz/service-accounts-admin/#service-account-admission-controller
+// which is the path that the kubernetes service account controller mounts the jwt token
+const defaultK8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func newK8sAuth(cfg *Config) (AuthMethod, error) {
+	tokenPath := cfg.K8sTokenPath
+	if tokenPath == "" {
+		tokenPath = defaultK8sTokenPath
 	}
-	return client
+	return &k8sAuth{
+		role:      cfg.K8sRole,
+		path:      cfg.K8sPath,
+		tokenPath: tokenPath,
+		audience:  cfg.K8sAudience,
+	}, nil
 }
 
-func (k *k8sAuth) VaultClient() (*api.Client, error) {
-	if !k.auth.IsTokenExpired() {
-		return k.client, nil
-	}
-	var err error
-	k.auth, err = k.getAuth()
+func (k *k8sAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	jwt, err := k.serviceAccountToken()
 	if err != nil {
 		return nil, err
 	}
-	k.client.SetToken(k.auth.token)
-	return k.client, nil
+	data := map[string]interface{}{
+		"jwt":  jwt,
+		"role": k.role,
+	}
+	return writeWithContext(ctx, client, fmt.Sprintf("auth/%s/login", k.path), data)
 }
 
-func (k *k8sAuth) getAuth() (*Auth, error) {
-	resp, err := k.login()
-	if err != nil {
-		return nil, err
-	}
+func (k *k8sAuth) CacheIdentity() string {
+	return cacheIdentity(K8s, k.path, k.role, k.tokenPath)
+}
 
-	tokenTtl, err := resp.TokenTTL()
+// serviceAccountToken reads the service account token, which the kubelet
+// rotates, on every login.
+func (k *k8sAuth) serviceAccountToken() (string, error) {
+	data, err := ioutil.ReadFile(k.tokenPath)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	return &Auth{
-		token:  resp.Auth.ClientToken,
-		expiry: time.Now().UTC().Add(tokenTtl),
-	}, nil
+	jwt := strings.TrimSpace(string(data))
+	if k.audience != "" {
+		if err := checkJwtAudience(jwt, k.audience); err != nil {
+			return "", err
+		}
+	}
+	return jwt, nil
 }
 
-func (k *k8sAuth) login() (*api.Secret, error) {
-	// this path comes from https://kubernetes.io/docs/reference/access-authn-authz/service-accounts-admin/#service-account-admission-controller
-	// which is the path that the kubernetes service account controller mounts the jwt token
-	jwt, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+// checkJwtAudience returns an error unless audience is one of the audiences
+// the jwt is bound to.
+func checkJwtAudience(jwt, audience string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("service account token is not a jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("decoding service account token: %w", err)
 	}
-	data := map[string]interface{}{
-		"jwt":  string(jwt),
-		"role": k.role,
+	var claims struct {
+		Aud json.RawMessage `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("decoding service account token: %w", err)
+	}
+
+	var audiences []string
+	if err := json.Unmarshal(claims.Aud, &audiences); err != nil {
+		var single string
+		if err := json.Unmarshal(claims.Aud, &single); err != nil {
+			return fmt.Errorf("service account token is not bound to audience '%s'", audience)
+		}
+		audiences = []string{single}
+	}
+	for _, aud := range audiences {
+		if aud == audience {
+			return nil
+		}
 	}
-	return k.client.Logical().Write(fmt.Sprintf("auth/%s/login", k.path), data)
+	return fmt.Errorf("service account token is not bound to audience '%s' but to %v", audience, audiences)
 }
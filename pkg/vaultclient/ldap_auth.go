@@ -0,0 +1,44 @@
+package vaultclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultLdapPath = "ldap"
+
+func newLdapAuth(cfg *Config) (AuthMethod, error) {
+	if err := checkUsername(Ldap, cfg.LdapUsername); err != nil {
+		return nil, err
+	}
+	if cfg.LdapPassword == nil {
+		return nil, errors.New("ldap auth requires a password source")
+	}
+	path := cfg.LdapPath
+	if path == "" {
+		path = defaultLdapPath
+	}
+	return &ldapAuth{
+		username: cfg.LdapUsername,
+		password: cfg.LdapPassword,
+		path:     path,
+	}, nil
+}
+
+func (l *ldapAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	password, err := l.password()
+	if err != nil {
+		return nil, fmt.Errorf("reading ldap password: %w", err)
+	}
+	data := map[string]interface{}{
+		"password": password,
+	}
+	return writeEscapedWithContext(ctx, client, usernameLoginPath(l.path, l.username), data)
+}
+
+func (l *ldapAuth) CacheIdentity() string {
+	return cacheIdentity(Ldap, l.path, l.username)
+}
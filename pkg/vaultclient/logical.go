@@ -0,0 +1,159 @@
+package vaultclient
+
+import (
+	"context"
+	"io"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// writeWithContext behaves like Logical().Write, but the request is bound to
+// ctx so that it can be cancelled or given a deadline by the caller.
+func writeWithContext(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	return writeRequestWithContext(ctx, client, client.NewRequest("PUT", "/v1/"+path), data)
+}
+
+// writeEscapedWithContext is writeWithContext for a path whose segments have
+// been escaped with url.PathEscape. They are sent escaped, rather than being
+// escaped a second time.
+func writeEscapedWithContext(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/"+path)
+	unescaped, err := url.PathUnescape(r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	r.URL.RawPath = r.URL.Path
+	r.URL.Path = unescaped
+	return writeRequestWithContext(ctx, client, r, data)
+}
+
+func writeRequestWithContext(ctx context.Context, client *api.Client, r *api.Request, data map[string]interface{}) (*api.Secret, error) {
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		secret, parseErr := api.ParseSecret(resp.Body)
+		switch parseErr {
+		case nil:
+		case io.EOF:
+			return nil, nil
+		default:
+			return nil, err
+		}
+		if secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0) {
+			return secret, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// revokeSelfWithContext revokes token using auth/token/revoke-self.
+func revokeSelfWithContext(ctx context.Context, client *api.Client, token string) error {
+	r := client.NewRequest("PUT", "/v1/auth/token/revoke-self")
+	r.ClientToken = token
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+// lookupSelfWithContext looks up token using auth/token/lookup-self.
+func lookupSelfWithContext(ctx context.Context, client *api.Client, token string) (*api.Secret, error) {
+	r := client.NewRequest("GET", "/v1/auth/token/lookup-self")
+	r.ClientToken = token
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// renewSelfWithContext renews token using auth/token/renew-self.
+func renewSelfWithContext(ctx context.Context, client *api.Client, token string, increment int) (*api.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/auth/token/renew-self")
+	r.ClientToken = token
+	if err := r.SetJSONBody(map[string]interface{}{"increment": increment}); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// unwrapWithContext unwraps the response wrapped in wrappingToken using
+// sys/wrapping/unwrap.
+func unwrapWithContext(ctx context.Context, client *api.Client, wrappingToken string) (*api.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/sys/wrapping/unwrap")
+	r.ClientToken = wrappingToken
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// readWithTokenContext behaves like Logical().Read, but authenticates with
+// token rather than the token of client.
+func readWithTokenContext(ctx context.Context, client *api.Client, token, path string) (*api.Secret, error) {
+	r := client.NewRequest("GET", "/v1/"+path)
+	r.ClientToken = token
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// writeWithTokenContext behaves like Logical().Write, but authenticates with
+// token rather than the token of client.
+func writeWithTokenContext(ctx context.Context, client *api.Client, token, path string, data map[string]interface{}) (*api.Secret, error) {
+	r := client.NewRequest("PUT", "/v1/"+path)
+	r.ClientToken = token
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
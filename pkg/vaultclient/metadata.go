@@ -0,0 +1,47 @@
+package vaultclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// metadataTimeout bounds requests to cloud metadata services, which answer
+// quickly when they are there at all.
+var metadataTimeout = time.Second * 10
+
+func newMetadataClient() *http.Client {
+	return &http.Client{Timeout: metadataTimeout}
+}
+
+// getMetadata fetches url from a cloud metadata service. The services only
+// answer requests carrying their own header, given in header.
+func getMetadata(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, error) {
+	return requestMetadata(ctx, client, "GET", url, header)
+}
+
+func requestMetadata(ctx context.Context, client *http.Client, method, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
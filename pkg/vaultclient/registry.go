@@ -0,0 +1,76 @@
+package vaultclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// AuthMethod obtains a token by logging in to Vault. Login is given the client
+// to log in with and returns the secret holding the new token; expiry, renewal
+// and locking are taken care of by the VaultAuth that calls it.
+type AuthMethod interface {
+	Login(ctx context.Context, client *api.Client) (*api.Secret, error)
+}
+
+// CacheIdentifier is implemented by auth methods whose tokens may be kept in
+// the token cache. CacheIdentity describes who the method logs in as, such as
+// its mount and role, and a cached token is only reused by a method with the
+// same identity. The token of a method that does not implement it, or that
+// returns an empty identity, is never cached.
+type CacheIdentifier interface {
+	CacheIdentity() string
+}
+
+// CustomAuthType is the first auth type that is free for RegisterAuthMethod,
+// well clear of the built in auth types.
+const CustomAuthType AuthType = 1000
+
+// AuthMethodFactory creates the AuthMethod for an auth type from the config.
+type AuthMethodFactory func(cfg *Config) (AuthMethod, error)
+
+var (
+	authMethodsMux sync.RWMutex
+	authMethods    = map[AuthType]AuthMethodFactory{
+		Token:     newTokenAuth,
+		Iam:       newIamAuth,
+		AppRole:   newAppRoleAuth,
+		K8s:       newK8sAuth,
+		Userpass:  newUserpassAuth,
+		Ldap:      newLdapAuth,
+		Cert:      newCertAuth,
+		Jwt:       newJwtAuth,
+		Gcp:       newGcpAuth,
+		Azure:     newAzureAuth,
+		Ec2:       newEc2Auth,
+		AgentSink: newAgentSinkAuth,
+	}
+)
+
+// RegisterAuthMethod makes an auth method available to NewVaultAuth, both as
+// Config.AuthType and as part of Config.AuthChain. Pick an authType from
+// CustomAuthType upwards, so that it does not clash with the built in auth
+// types. Registering an auth type twice is an error.
+func RegisterAuthMethod(authType AuthType, factory AuthMethodFactory) error {
+	authMethodsMux.Lock()
+	defer authMethodsMux.Unlock()
+
+	if _, found := authMethods[authType]; found {
+		return fmt.Errorf("auth type '%s' is already registered", authType)
+	}
+	authMethods[authType] = factory
+	return nil
+}
+
+func newAuthMethod(cfg *Config, authType AuthType) (AuthMethod, error) {
+	authMethodsMux.RLock()
+	factory, found := authMethods[authType]
+	authMethodsMux.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown auth type '%d'", authType)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,119 @@
+package vaultclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+var (
+	// reloginInterval is how long the renewer waits before trying again after
+	// a failed login.
+	reloginInterval = time.Second * 5
+)
+
+// renewer keeps a token alive in the background. It renews the token via
+// renew-self for as long as Vault allows and logs in again once the token
+// reaches its max TTL.
+type renewer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneCh chan struct{}
+}
+
+func (m *tokenManager) startRenewer(secret *api.Secret) *renewer {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &renewer{
+		ctx:    ctx,
+		cancel: cancel,
+		doneCh: make(chan struct{}),
+	}
+	go m.runRenewer(r, secret)
+	return r
+}
+
+func (r *renewer) stop() {
+	if r == nil {
+		return
+	}
+	r.cancel()
+}
+
+func (r *renewer) wait() {
+	if r == nil {
+		return
+	}
+	<-r.doneCh
+}
+
+func (m *tokenManager) runRenewer(r *renewer, secret *api.Secret) {
+	defer close(r.doneCh)
+
+	// there is nothing to renew for a token that never expires
+	if secret.Auth != nil && secret.Auth.LeaseDuration == 0 {
+		<-r.ctx.Done()
+		return
+	}
+
+	for {
+		if !m.watch(r, secret) {
+			return
+		}
+
+		var ok bool
+		secret, ok = m.relogin(r)
+		if !ok {
+			return
+		}
+	}
+}
+
+// watch renews the token held in secret until it can no longer be extended.
+// It returns false if the renewer was stopped in the meantime.
+func (m *tokenManager) watch(r *renewer, secret *api.Secret) bool {
+	watcher, err := m.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret: secret,
+	})
+	if err != nil {
+		return false
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return false
+		case <-watcher.DoneCh():
+			return true
+		case renewal := <-watcher.RenewCh():
+			if !m.setRenewedAuth(r, TokenRenewed, renewal.Secret) {
+				return false
+			}
+		}
+	}
+}
+
+// relogin logs in until it succeeds or the renewer is stopped.
+func (m *tokenManager) relogin(r *renewer) (*api.Secret, bool) {
+	for {
+		secret, err := m.login(r.ctx)
+		if err == nil {
+			if !m.setRenewedAuth(r, TokenRotated, secret) {
+				m.discard(secret)
+				return nil, false
+			}
+			return secret, true
+		}
+		if r.ctx.Err() == nil {
+			m.notifyLoginFailed(err)
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return nil, false
+		case <-time.After(reloginInterval):
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package vaultclient
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// RetryPolicy controls how often a failed login is retried. Only failures for
+// which IsRetryableError reports true are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of login attempts, including the first.
+	// Each attempt may itself be retried by the Vault client, see
+	// Config.LoginRetry.
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failed attempt. It doubles after
+	// every further failure, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the fraction of each wait, between 0 and 1, that is randomised
+	// so that many clients do not retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns a policy suited to riding out a Vault leader
+// election or a short burst of 5xx/429 responses.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: time.Millisecond * 500,
+		MaxBackoff:  time.Second * 10,
+		Jitter:      0.2,
+	}
+}
+
+// IsRetryableError reports whether a failed login may succeed if attempted
+// again. Timeouts, failures to connect, refused or reset connections, 5xx
+// responses (which include a sealed Vault or one without an active node) and
+// 429 responses are retryable. Any other response from Vault, such as 400 for
+// an invalid role or 403 for permission denied, is permanent, as are a TLS
+// certificate that fails verification and a request that cannot be made,
+// such as one for a url without a host.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var chainErr *AuthChainError
+	if errors.As(err, &chainErr) {
+		for _, attempt := range chainErr.Attempts {
+			if IsRetryableError(attempt.Err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var iamErr *IamLoginError
+	if errors.As(err, &iamErr) {
+		for _, attempt := range iamErr.Attempts {
+			if IsRetryableError(attempt.Err) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == 429 || respErr.StatusCode >= 500
+	}
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateErr x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certificateErr) {
+		return false
+	}
+
+	// only failures to reach Vault, not requests that could never be made
+	// such as one with an unsupported scheme or without a host
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// login calls login until it succeeds, fails with an error that is not
+// retryable, runs out of attempts or ctx is done. A nil policy makes a single
+// attempt.
+func (p *RetryPolicy) login(ctx context.Context, login func(ctx context.Context) (*api.Secret, error)) (*api.Secret, error) {
+	for attempt := 1; ; attempt++ {
+		secret, err := login(ctx)
+		if err == nil || p == nil || attempt >= p.MaxAttempts || !IsRetryableError(err) {
+			return secret, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(p.backoff(attempt)):
+		}
+	}
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.BaseBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		wait -= time.Duration(rand.Float64() * jitter * float64(wait))
+	}
+	return wait
+}
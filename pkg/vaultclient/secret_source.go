@@ -0,0 +1,44 @@
+package vaultclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SecretSource returns a secret, such as a password or JWT, needed to log in.
+// It is called on every login so that a secret rotated in its file or env var
+// is picked up without restarting. Any func with this signature can be used
+// to fetch the secret some other way.
+type SecretSource func() (string, error)
+
+// SecretFromString returns a SecretSource for a literal secret.
+func SecretFromString(secret string) SecretSource {
+	return func() (string, error) {
+		return secret, nil
+	}
+}
+
+// SecretFromEnv reads the secret from an env var.
+func SecretFromEnv(name string) SecretSource {
+	return func() (string, error) {
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("secret env var '%s' is not set", name)
+		}
+		return value, nil
+	}
+}
+
+// SecretFromFile reads the secret from a file, ignoring surrounding
+// whitespace.
+func SecretFromFile(path string) SecretSource {
+	return func() (string, error) {
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret: %w", err)
+		}
+		return strings.TrimSpace(string(value)), nil
+	}
+}
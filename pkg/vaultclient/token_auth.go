@@ -0,0 +1,203 @@
+package vaultclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+)
+
+// TokenExpiringError is reported when the token given for Token auth cannot
+// be renewed any further. It is sent in a TokenExpiring event once a third of
+// the token's lifetime is left, and returned by VaultClient once the token
+// has expired.
+type TokenExpiringError struct {
+	Accessor string
+	Expiry   time.Time
+}
+
+func (e *TokenExpiringError) Error() string {
+	return fmt.Sprintf("vault token '%s' cannot be renewed and expires at %s", e.Accessor, e.Expiry.Format(time.RFC3339))
+}
+
+func newTokenAuth(cfg *Config) (AuthMethod, error) {
+	return &tokenAuth{
+		token:  cfg.Token,
+		looked: make(chan struct{}),
+	}, nil
+}
+
+// Login looks the token up with lookup-self, so that its TTL is known and a
+// token which is no longer valid lets an auth chain fall through to the next
+// method. A renewable token with less than a third of its lifetime left is
+// renewed with renew-self. Once a token that could not be renewed has
+// expired a TokenExpiringError is returned.
+func (t *tokenAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	lookup, err := lookupSelfWithContext(ctx, client, t.token)
+	if err != nil {
+		if expired := t.expired(); expired != nil {
+			return nil, expired
+		}
+		return nil, err
+	}
+
+	ttl, err := lookup.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	renewable, err := lookup.TokenIsRenewable()
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := lookup.TokenAccessor()
+	if err != nil {
+		return nil, err
+	}
+	period, err := durationField(lookup, "period")
+	if err != nil {
+		return nil, err
+	}
+	creationTtl, err := durationField(lookup, "creation_ttl")
+	if err != nil {
+		return nil, err
+	}
+	// a periodic token lives for its period at a time, anything else for the
+	// TTL it was created with
+	lifetime := period
+	if lifetime == 0 {
+		lifetime = creationTtl
+	}
+	if lifetime == 0 {
+		lifetime = ttl
+	}
+
+	// Vault reports a TTL of zero for tokens that never expire, and a TTL
+	// in whole seconds
+	if ttl != 0 && renewable && ttl <= lifetime/3+time.Second {
+		// a periodic token is renewed for its period, anything else for its
+		// default TTL
+		renewal, err := renewSelfWithContext(ctx, client, t.token, int(period.Seconds()))
+		if err != nil {
+			return nil, err
+		}
+		if ttl, err = renewal.TokenTTL(); err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	t.accessor = accessor
+	t.renewable = renewable
+	t.lifetime = lifetime
+	t.expiry = time.Time{}
+	if ttl != 0 {
+		t.expiry = time.Now().UTC().Add(ttl)
+	}
+	t.mu.Unlock()
+	if t.looked != nil {
+		t.lookedOnce.Do(func() { close(t.looked) })
+	}
+
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken:   t.token,
+			Accessor:      accessor,
+			LeaseDuration: int(ttl.Seconds()),
+			Renewable:     renewable,
+		},
+	}, nil
+}
+
+// expired returns a TokenExpiringError if the token is known to have
+// expired.
+func (t *tokenAuth) expired() error {
+	if _, _, expiry := t.renewal(); expiry.IsZero() || time.Now().Before(expiry) {
+		return nil
+	}
+	return t.expiringError()
+}
+
+func (t *tokenAuth) expiringError() *TokenExpiringError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &TokenExpiringError{Accessor: t.accessor, Expiry: t.expiry}
+}
+
+// watch renews the token ahead of time, calling changed once a third of its
+// lifetime is left so that it is renewed whether or not VaultClient is
+// called. A token that cannot be renewed past that point, as it is not
+// renewable or has reached its max TTL, is reported to expiring, once. A
+// renewal that fails is reported as a failed login by changed, and retried.
+// Only a renewable token is watched for as long as it lives: watch returns
+// straight away for a token that never expires, and once a token that cannot
+// be renewed has expired.
+func (t *tokenAuth) watch(ctx context.Context, changed func(), expiring func(error)) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-t.looked:
+	}
+
+	warned := false
+	for {
+		renewable, renewAt, expiry := t.renewal()
+		if expiry.IsZero() || !time.Now().Before(expiry) {
+			return
+		}
+
+		wait := time.Until(renewAt)
+		renewed := false
+		if wait <= 0 && renewable {
+			changed()
+			// a successful lookup or renewal always moves the expiry
+			var renewedExpiry time.Time
+			renewable, renewAt, renewedExpiry = t.renewal()
+			renewed = !renewedExpiry.Equal(expiry)
+			expiry = renewedExpiry
+			wait = time.Until(renewAt)
+		}
+		if wait <= 0 {
+			if !warned && (!renewable || renewed) {
+				expiring(t.expiringError())
+				warned = true
+			}
+			// keep trying a renewable token, which may be renewed for less
+			// than its lifetime at its max TTL
+			wait = time.Until(expiry)
+			if renewable && wait > reloginInterval {
+				wait = reloginInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renewal returns whether the token is renewable, when it is due to be
+// renewed and when it expires.
+func (t *tokenAuth) renewal() (bool, time.Time, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.renewable, t.expiry.Add(-t.lifetime / 3), t.expiry
+}
+
+// durationField returns the duration held in field of the data of secret,
+// zero if there is none.
+func durationField(secret *api.Secret, field string) (time.Duration, error) {
+	raw, found := secret.Data[field]
+	if !found || raw == nil {
+		return 0, nil
+	}
+	return parseutil.ParseDurationSecond(raw)
+}
+
+// borrowed reports the token given in the config, which is not ours to revoke.
+func (t *tokenAuth) borrowed(token string) bool {
+	return token == t.token
+}
@@ -0,0 +1,282 @@
+package vaultclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	tokenCacheMagic = "vct2"
+	tokenCacheSalt  = 16
+	// tokenCacheIterations makes each guess at a low entropy key, such as a
+	// passphrase, expensive for anyone holding a copy of the cache file.
+	tokenCacheIterations = 600000
+)
+
+// TokenCacheKeySource returns the secret material that the token cache
+// encryption key is derived from.
+type TokenCacheKeySource func() ([]byte, error)
+
+// TokenCacheKeyFromEnv reads the token cache key material from an env var.
+func TokenCacheKeyFromEnv(name string) TokenCacheKeySource {
+	return func() ([]byte, error) {
+		value := os.Getenv(name)
+		if value == "" {
+			return nil, fmt.Errorf("token cache key env var '%s' is not set", name)
+		}
+		return []byte(value), nil
+	}
+}
+
+// TokenCacheKeyFromFile reads the token cache key material from a file.
+func TokenCacheKeyFromFile(path string) TokenCacheKeySource {
+	return func() ([]byte, error) {
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading token cache key: %w", err)
+		}
+		return bytes.TrimSpace(value), nil
+	}
+}
+
+// tokenCache keeps a token in a file only readable by the current user,
+// encrypted with AES-GCM under a key derived from the key source with
+// PBKDF2, salted with a random salt kept in the file header.
+type tokenCache struct {
+	path    string
+	key     TokenCacheKeySource
+	address string
+	method  CacheIdentifier
+
+	// salt and aead are the salt of the file last read or written and the
+	// cipher derived for it, kept as deriving it is deliberately slow.
+	// stored is the token the file holds, which is not written again.
+	mu     sync.Mutex
+	salt   []byte
+	aead   cipher.AEAD
+	stored string
+}
+
+// cachedToken is the token cache contents. The token is only used by a
+// process logging in to the same Vault with the same identity, once Vault
+// confirms that it is still valid.
+type cachedToken struct {
+	Address  string `json:"address"`
+	Identity string `json:"identity"`
+	Token    string `json:"token"`
+}
+
+// newTokenCache returns the token cache for method, or nil if there is none
+// or method does not say who it logs in as. It fails if the token cache key
+// cannot be read.
+func newTokenCache(cfg *Config, method AuthMethod) (*tokenCache, error) {
+	if cfg.TokenCachePath == "" {
+		return nil, nil
+	}
+	if cfg.TokenCacheKey == nil {
+		return nil, errors.New("token cache requires a token cache key")
+	}
+	if _, err := cfg.TokenCacheKey(); err != nil {
+		return nil, fmt.Errorf("reading token cache key: %w", err)
+	}
+	identifier, ok := method.(CacheIdentifier)
+	if !ok {
+		return nil, nil
+	}
+	return &tokenCache{
+		path:    cfg.TokenCachePath,
+		key:     cfg.TokenCacheKey,
+		address: strings.TrimSuffix(cfg.Address, "/"),
+		method:  identifier,
+	}, nil
+}
+
+// cacheIdentityResolver is implemented by auth methods that need to fetch
+// something, such as credentials, before CacheIdentity can say who they log in
+// as. It is called before the token cache is read.
+type cacheIdentityResolver interface {
+	resolveCacheIdentity(ctx context.Context) error
+}
+
+// cacheIdentity joins the auth type and the fields that identify who an auth
+// method logs in as, such as its mount and role.
+func cacheIdentity(authType AuthType, fields ...string) string {
+	identity := make([]string, 0, len(fields)+1)
+	identity = append(identity, authType.String())
+	for _, field := range fields {
+		identity = append(identity, strconv.Quote(field))
+	}
+	return strings.Join(identity, " ")
+}
+
+// load returns the cached token, or an empty token if there is none for this
+// Vault and identity.
+func (c *tokenCache) load() (string, error) {
+	identity := c.method.CacheIdentity()
+	if identity == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(data) < len(tokenCacheMagic)+tokenCacheSalt || string(data[:len(tokenCacheMagic)]) != tokenCacheMagic {
+		return "", fmt.Errorf("token cache '%s' is not in a known format", c.path)
+	}
+	header := data[:len(tokenCacheMagic)+tokenCacheSalt]
+	aead, err := c.aeadFor(header[len(tokenCacheMagic):])
+	if err != nil {
+		return "", err
+	}
+	sealed := data[len(header):]
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("token cache '%s' is truncated", c.path)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], header)
+	if err != nil {
+		return "", fmt.Errorf("decrypting token cache: %w", err)
+	}
+
+	var cached cachedToken
+	if err := json.Unmarshal(plaintext, &cached); err != nil {
+		return "", err
+	}
+	if cached.Address != c.address || cached.Identity != identity {
+		return "", nil
+	}
+	c.stored = cached.Token
+	return cached.Token, nil
+}
+
+// store replaces the cached token with auth. Nothing is stored while the
+// auth method cannot say who it logs in as, nor when the file already holds
+// the token, such as after it has been renewed.
+func (c *tokenCache) store(auth *Auth) error {
+	identity := c.method.CacheIdentity()
+	if identity == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth.token == c.stored {
+		return nil
+	}
+	plaintext, err := json.Marshal(cachedToken{
+		Address:  c.address,
+		Identity: identity,
+		Token:    auth.token,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the salt of the file is kept, with each write sealed under a new nonce
+	salt := c.salt
+	if salt == nil {
+		salt = make([]byte, tokenCacheSalt)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+	}
+	aead, err := c.aeadFor(salt)
+	if err != nil {
+		return err
+	}
+	header := append([]byte(tokenCacheMagic), salt...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append(header, nonce...)
+	data = aead.Seal(data, nonce, plaintext, header)
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return err
+	}
+	c.stored = auth.token
+	return nil
+}
+
+func (c *tokenCache) remove() error {
+	c.mu.Lock()
+	c.stored = ""
+	c.mu.Unlock()
+
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// aeadFor returns the cipher for salt, only deriving the key again when salt
+// differs from the last one.
+func (c *tokenCache) aeadFor(salt []byte) (cipher.AEAD, error) {
+	if c.aead != nil && bytes.Equal(salt, c.salt) {
+		return c.aead, nil
+	}
+	material, err := c.key()
+	if err != nil {
+		return nil, err
+	}
+	if len(material) == 0 {
+		return nil, fmt.Errorf("token cache key is empty")
+	}
+
+	key := pbkdf2.Key(material, salt, tokenCacheIterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.salt = append([]byte(nil), salt...)
+	c.aead = aead
+	return aead, nil
+}
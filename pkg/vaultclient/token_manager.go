@@ -0,0 +1,370 @@
+package vaultclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// neverExpires is the expiry of a token without a TTL.
+var neverExpires = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// tokenManager is the VaultAuth for every auth method that obtains its token
+// by logging in. It owns the token: it logs in when the token is missing or
+// close to expiry, renews it in the background when asked to, and revokes it
+// on Close.
+type tokenManager struct {
+	notifier
+
+	client *api.Client
+	method AuthMethod
+	renew  bool
+	retry  *RetryPolicy
+	cache  *tokenCache
+
+	mu       sync.Mutex
+	auth     *Auth
+	renewer  *renewer
+	inflight *loginCall
+	closed   bool
+	// stopWatch stops watching a tokenWatcher method for new tokens.
+	stopWatch context.CancelFunc
+}
+
+// tokenBorrower is implemented by auth methods that hand out tokens owned by
+// someone else, such as the token from Config.Token or a Vault Agent sink.
+// Borrowed tokens are never revoked or cached.
+type tokenBorrower interface {
+	borrowed(token string) bool
+}
+
+// tokenWatcher is implemented by auth methods that look after their token
+// between logins. watch is started once the method has first logged in. It
+// calls changed whenever Login should be called again, such as when it would
+// return a new token, and expiring when the token is going to expire without
+// a way to keep it, until ctx is done.
+type tokenWatcher interface {
+	watch(ctx context.Context, changed func(), expiring func(error))
+}
+
+// loginCall is a login in progress, shared by every caller that finds the
+// token expired while it runs.
+type loginCall struct {
+	done      chan struct{}
+	err       error
+	abandoned bool
+}
+
+func newTokenManager(client *api.Client, method AuthMethod, cfg *Config) (*tokenManager, error) {
+	cache, err := newTokenCache(cfg, method)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenManager{
+		client: client,
+		method: method,
+		renew:  cfg.BackgroundRenewal,
+		retry:  cfg.LoginRetry,
+		cache:  cache,
+	}, nil
+}
+
+// startWatching starts watching a tokenWatcher method once it has first
+// logged in, so that nothing is left running for a method that never does.
+// It must be called with mu held.
+func (m *tokenManager) startWatching() {
+	watcher, ok := m.method.(tokenWatcher)
+	if !ok || m.stopWatch != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopWatch = cancel
+	go watcher.watch(ctx, func() {
+		m.refresh(ctx)
+	}, m.notifyTokenExpiring)
+}
+
+func newAuth(secret *api.Secret) (*Auth, error) {
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("no auth info returned by vault")
+	}
+
+	tokenTtl, err := secret.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	// Vault reports a TTL of zero for tokens that never expire, such as root
+	// tokens
+	if tokenTtl == 0 {
+		return &Auth{
+			token:  secret.Auth.ClientToken,
+			expiry: neverExpires,
+		}, nil
+	}
+
+	return &Auth{
+		token:  secret.Auth.ClientToken,
+		expiry: time.Now().UTC().Add(tokenTtl),
+	}, nil
+}
+
+func (m *tokenManager) VaultClient() (*api.Client, error) {
+	return m.VaultClientContext(context.Background())
+}
+
+// VaultClientContext returns the client, logging in first if the token is
+// missing or about to expire. Callers that find the token expired while a
+// login is in progress wait for that login and share its result, or give up
+// once ctx is done.
+func (m *tokenManager) VaultClientContext(ctx context.Context) (*api.Client, error) {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if !m.auth.IsTokenExpired() {
+			m.mu.Unlock()
+			return m.client, nil
+		}
+
+		call := m.inflight
+		if call == nil {
+			call = &loginCall{done: make(chan struct{})}
+			m.inflight = call
+			m.mu.Unlock()
+
+			call.err = m.doLogin(ctx)
+			call.abandoned = ctx.Err() != nil
+
+			m.mu.Lock()
+			m.inflight = nil
+			m.mu.Unlock()
+			close(call.done)
+
+			if call.err != nil {
+				// only once the login is over, so that subscribers can ask
+				// for a client themselves
+				if call.err != ErrClosed {
+					m.notifyLoginFailed(call.err)
+				}
+				return nil, call.err
+			}
+			return m.client, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-call.done:
+		}
+
+		if call.err == nil {
+			return m.client, nil
+		}
+		// the caller that started the login gave up on it, so its error says
+		// nothing about whether a login on our behalf would succeed
+		if !call.abandoned {
+			return nil, call.err
+		}
+	}
+}
+
+// login logs in with the auth method, retrying as the retry policy allows.
+func (m *tokenManager) login(ctx context.Context) (*api.Secret, error) {
+	return m.retry.login(ctx, func(ctx context.Context) (*api.Secret, error) {
+		return m.method.Login(ctx, m.client)
+	})
+}
+
+// doLogin logs in and stores the token. It returns ErrClosed if the token
+// manager was closed in the meantime, and otherwise the error of the login.
+func (m *tokenManager) doLogin(ctx context.Context) error {
+	if secret, auth := m.cachedLogin(ctx); secret != nil {
+		return m.setAuth(secret, auth)
+	}
+
+	secret, err := m.login(ctx)
+	if err != nil {
+		return err
+	}
+	auth, err := newAuth(secret)
+	if err != nil {
+		return err
+	}
+	return m.setAuth(secret, auth)
+}
+
+// refresh logs in again when a tokenWatcher method asks for it. The current
+// token is kept if the login fails.
+func (m *tokenManager) refresh(ctx context.Context) {
+	secret, err := m.login(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.notifyLoginFailed(err)
+		}
+		return
+	}
+	auth, err := newAuth(secret)
+	if err != nil {
+		m.notifyLoginFailed(err)
+		return
+	}
+	_ = m.setAuth(secret, auth)
+}
+
+func (m *tokenManager) setAuth(secret *api.Secret, auth *Auth) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		m.discard(secret)
+		return ErrClosed
+	}
+	eventType := TokenRotated
+	if m.auth != nil && m.auth.token == auth.token {
+		eventType = TokenRenewed
+		// a token that was looked up again rather than renewed keeps its
+		// expiry, give or take the whole seconds Vault reports its TTL in
+		if !auth.expiry.After(m.auth.expiry.Add(time.Second)) {
+			eventType = 0
+		}
+	}
+	m.auth = auth
+	m.client.SetToken(auth.token)
+	previous := m.renewer
+	m.renewer = nil
+	// a borrowed token is kept alive by its owner, or by a tokenWatcher
+	if m.renew && !m.isBorrowed(auth.token) {
+		m.renewer = m.startRenewer(secret)
+	}
+	m.startWatching()
+	m.mu.Unlock()
+
+	previous.stop()
+	m.storeCachedToken(auth)
+	if eventType != 0 {
+		m.notifyToken(eventType, secret, auth)
+	}
+	return nil
+}
+
+// cachedLogin returns the token from the token cache, if there is one that
+// Vault still accepts and that is not about to expire.
+func (m *tokenManager) cachedLogin(ctx context.Context) (*api.Secret, *Auth) {
+	if m.cache == nil {
+		return nil, nil
+	}
+	if resolver, ok := m.method.(cacheIdentityResolver); ok {
+		if err := resolver.resolveCacheIdentity(ctx); err != nil {
+			return nil, nil
+		}
+	}
+	cached, err := m.cache.load()
+	if err != nil || cached == "" {
+		return nil, nil
+	}
+
+	secret, err := (&tokenAuth{token: cached}).Login(ctx, m.client)
+	if err != nil {
+		return nil, nil
+	}
+	auth, err := newAuth(secret)
+	if err != nil || auth.IsTokenExpired() {
+		return nil, nil
+	}
+	return secret, auth
+}
+
+// storeCachedToken writes auth to the token cache. The cache is best effort,
+// so a failure to write it does not fail the login.
+func (m *tokenManager) storeCachedToken(auth *Auth) {
+	if m.cache == nil || m.isBorrowed(auth.token) {
+		return
+	}
+	_ = m.cache.store(auth)
+}
+
+func (m *tokenManager) VaultClientOrPanic() *api.Client {
+	client, err := m.VaultClient()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Close stops the background renewer, if one is running, and revokes the
+// current token. Any later call for a client fails with ErrClosed.
+func (m *tokenManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	if m.stopWatch != nil {
+		m.stopWatch()
+	}
+	r := m.renewer
+	m.renewer = nil
+	auth := m.auth
+	m.auth = nil
+	m.mu.Unlock()
+
+	r.stop()
+	r.wait()
+
+	defer m.client.ClearToken()
+	if auth == nil || m.isBorrowed(auth.token) {
+		return nil
+	}
+	if m.cache != nil {
+		// the cached token is about to be revoked
+		_ = m.cache.remove()
+	}
+	if err := revokeSelfWithContext(ctx, m.client, auth.token); err != nil {
+		return fmt.Errorf("revoking vault token: %w", err)
+	}
+	return nil
+}
+
+// discard revokes a token that was obtained but is not going to be used, so
+// that it does not linger until its TTL runs out.
+func (m *tokenManager) discard(secret *api.Secret) {
+	if secret == nil || secret.Auth == nil || m.isBorrowed(secret.Auth.ClientToken) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	_ = revokeSelfWithContext(ctx, m.client, secret.Auth.ClientToken)
+}
+
+func (m *tokenManager) isBorrowed(token string) bool {
+	borrower, ok := m.method.(tokenBorrower)
+	return ok && borrower.borrowed(token)
+}
+
+// setRenewedAuth stores a token obtained by r, unless r has been replaced or
+// stopped in the meantime.
+func (m *tokenManager) setRenewedAuth(r *renewer, eventType TokenEventType, secret *api.Secret) bool {
+	auth, err := newAuth(secret)
+	if err != nil {
+		return true
+	}
+
+	m.mu.Lock()
+	if m.renewer != r {
+		m.mu.Unlock()
+		return false
+	}
+	m.auth = auth
+	m.client.SetToken(auth.token)
+	m.mu.Unlock()
+
+	m.storeCachedToken(auth)
+	m.notifyToken(eventType, secret, auth)
+	return true
+}
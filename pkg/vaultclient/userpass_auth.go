@@ -0,0 +1,60 @@
+package vaultclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultUserpassPath = "userpass"
+
+func newUserpassAuth(cfg *Config) (AuthMethod, error) {
+	if err := checkUsername(Userpass, cfg.UserpassUsername); err != nil {
+		return nil, err
+	}
+	if cfg.UserpassPassword == "" {
+		return nil, errors.New("userpass auth requires a password")
+	}
+	path := cfg.UserpassPath
+	if path == "" {
+		path = defaultUserpassPath
+	}
+	return &userpassAuth{
+		username: cfg.UserpassUsername,
+		password: cfg.UserpassPassword,
+		path:     path,
+	}, nil
+}
+
+func (u *userpassAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data := map[string]interface{}{
+		"password": u.password,
+	}
+	return writeEscapedWithContext(ctx, client, usernameLoginPath(u.path, u.username), data)
+}
+
+func (u *userpassAuth) CacheIdentity() string {
+	return cacheIdentity(Userpass, u.path, u.username)
+}
+
+// checkUsername returns an error for a username that cannot be logged in
+// with by auth methods that take the username in the login path.
+func checkUsername(authType AuthType, username string) error {
+	switch username {
+	case "":
+		return fmt.Errorf("%s auth requires a username", authType)
+	case ".", "..":
+		return fmt.Errorf("%s auth username '%s' is not valid", authType, username)
+	}
+	return nil
+}
+
+// usernameLoginPath returns the path to log in as username on the auth
+// backend mounted at mount. The username is escaped so that it stays a single
+// path segment, to be sent with writeEscapedWithContext.
+func usernameLoginPath(mount, username string) string {
+	return fmt.Sprintf("auth/%s/login/%s", mount, url.PathEscape(username))
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestAgentSinkAuthSwapsTokenWrittenByAgent(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	sinkPath, cleanup := tempAgentSinkPath(t)
+	defer cleanup()
+
+	first := createToken(t, configuredVault, false)
+	writeAgentSink(t, sinkPath, first.Auth.ClientToken)
+
+	v := newAgentSinkAuth(t, configuredVault, sinkPath, false)
+	client := v.VaultClientOrPanic()
+	if client.Token() != first.Auth.ClientToken {
+		t.Fatalf("expected token from agent sink")
+	}
+
+	second := createToken(t, configuredVault, false)
+	writeAgentSink(t, sinkPath, second.Auth.ClientToken)
+
+	retry.RunWith(&retry.Timer{Timeout: 10 * time.Second, Wait: 200 * time.Millisecond}, t, func(r *retry.R) {
+		if client.Token() != second.Auth.ClientToken {
+			r.Fatal("token not swapped yet")
+		}
+	})
+}
+
+func TestAgentSinkAuthUnwrapsWrappedSink(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	sinkPath, cleanup := tempAgentSinkPath(t)
+	defer cleanup()
+
+	wrapped := createToken(t, configuredVault, true)
+	sink, err := json.Marshal(wrapped.WrapInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAgentSink(t, sinkPath, string(sink))
+
+	v := newAgentSinkAuth(t, configuredVault, sinkPath, true)
+	client := v.VaultClientOrPanic()
+	if client.Token() == "" || client.Token() == wrapped.WrapInfo.Token {
+		t.Fatalf("expected the unwrapped token to be used")
+	}
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected unwrapped token to be valid, error: %s", err)
+	}
+}
+
+func TestAgentSinkAuthCloseLeavesTokenAlone(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	sinkPath, cleanup := tempAgentSinkPath(t)
+	defer cleanup()
+
+	secret := createToken(t, configuredVault, false)
+	writeAgentSink(t, sinkPath, secret.Auth.ClientToken)
+
+	v := newAgentSinkAuth(t, configuredVault, sinkPath, false)
+	v.VaultClientOrPanic()
+	if err := v.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := configuredVault.rootClient.Auth().Token().Lookup(secret.Auth.ClientToken); err != nil {
+		t.Fatalf("expected agent token to outlive the vault auth, error: %s", err)
+	}
+}
+
+func newAgentSinkAuth(t *testing.T, configuredVault *configuredVault, sinkPath string, wrapped bool) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AgentSink
+	config.AgentSinkPath = sinkPath
+	config.AgentSinkWrapped = wrapped
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// createToken creates a token the way Vault Agent auto-auth would obtain one,
+// response-wrapped when wrapped is set.
+func createToken(t *testing.T, configuredVault *configuredVault, wrapped bool) *api.Secret {
+	client, err := configuredVault.rootClient.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(configuredVault.rootToken)
+	if wrapped {
+		client.SetWrappingLookupFunc(func(operation, path string) string {
+			return "5m"
+		})
+	}
+	secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"default"},
+		TTL:      "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func tempAgentSinkPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "agent-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "token"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// writeAgentSink writes the sink the way Vault Agent does, replacing the file
+// in one go.
+func writeAgentSink(t *testing.T, sinkPath, contents string) {
+	tmp := sinkPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, sinkPath); err != nil {
+		t.Fatal(err)
+	}
+}
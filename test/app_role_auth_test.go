@@ -1,11 +1,20 @@
 package test
 
 import (
+	"context"
+	"errors"
 	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/vault/api"
 )
 
@@ -67,3 +76,401 @@ func TestAppRoleAuth(t *testing.T) {
 	}
 
 }
+
+func TestAppRoleAuthBackgroundRenewalRenewsToken(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "3s", "1h")
+	defer destroy()
+
+	v := newAppRoleAuthWithBackgroundRenewal(t, configuredVault)
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+
+	client := v.VaultClientOrPanic()
+	token := client.Token()
+
+	// outlive the original ttl of the token
+	time.Sleep(time.Second * 6)
+
+	if client.Token() != token {
+		t.Fatalf("expected token to be renewed rather than replaced")
+	}
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected renewed token to still be valid, error: %s", err)
+	}
+}
+
+func TestAppRoleAuthBackgroundRenewalLogsInAtMaxTtl(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "2s", "4s")
+	defer destroy()
+
+	v := newAppRoleAuthWithBackgroundRenewal(t, configuredVault)
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+
+	client := v.VaultClientOrPanic()
+	token := client.Token()
+
+	retry.RunWith(&retry.Timer{Timeout: 30 * time.Second, Wait: 500 * time.Millisecond}, t, func(r *retry.R) {
+		if client.Token() == token {
+			r.Fatal("token not replaced yet")
+		}
+	})
+
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected new token to be valid, error: %s", err)
+	}
+}
+
+func newAppRoleAuthWithBackgroundRenewal(t *testing.T, configuredVault *configuredVault) vaultclient.VaultAuth {
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = roleID
+	config.AppRoleSecretId = secretID
+	config.BackgroundRenewal = true
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestAppRoleAuthConcurrentCallersShareOneLogin(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	// a secret id that can only be used once fails any login after the first
+	resp, err := configuredVault.rootClient.Logical().Write("auth/approle/role/test1/secret-id", map[string]interface{}{
+		"num_uses": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID := resp.Data["secret_id"].(string)
+	roleID, _ := appRoleCredentials(t, configuredVault, "test1")
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = roleID
+	config.AppRoleSecretId = secretID
+
+	err = config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.VaultClient(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("expected concurrent callers to share a single login, error: %s", err)
+	}
+}
+
+func TestAppRoleAuthLoginHonoursContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// never answer the login until the test is over
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = "roleid"
+	config.AppRoleSecretId = "secretid"
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := v.(vaultclient.VaultAuthContext).VaultClientContext(ctx); err == nil {
+		t.Fatalf("expected login to fail once the context deadline passed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected login to give up at the context deadline, took %s", elapsed)
+	}
+}
+
+func TestAppRoleAuthCloseRevokesToken(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	v := newAppRoleAuthWithBackgroundRenewal(t, configuredVault)
+
+	token := v.VaultClientOrPanic().Token()
+
+	if err := v.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatalf("could not close vault auth, error: %s", err)
+	}
+
+	if _, err := configuredVault.rootClient.Auth().Token().Lookup(token); err == nil {
+		t.Fatalf("expected token to be revoked on close")
+	}
+
+	if _, err := v.VaultClient(); !errors.Is(err, vaultclient.ErrClosed) {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+}
+
+func TestAppRoleAuthWithSecretIdFile(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+	secretIDFile, cleanup := writeSecretIdFile(t, secretID)
+	defer cleanup()
+
+	v := newAppRoleAuthWithSecretIdFile(t, configuredVault, roleID, secretIDFile, false)
+
+	if _, err := v.VaultClientOrPanic().Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected token to be valid, error: %s", err)
+	}
+	if _, err := os.Stat(secretIDFile); !os.IsNotExist(err) {
+		t.Fatalf("expected secret id file to be removed after use")
+	}
+}
+
+func TestAppRoleAuthWithWrappedSecretIdFile(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "12s", "1h")
+	defer destroy()
+
+	roleID, _ := appRoleCredentials(t, configuredVault, "test1")
+
+	wrappingClient, err := configuredVault.rootClient.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappingClient.SetToken(configuredVault.rootToken)
+	wrappingClient.SetWrappingLookupFunc(func(operation, path string) string {
+		return "5m"
+	})
+	wrapped, err := wrappingClient.Logical().Write("auth/approle/role/test1/secret-id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretIDFile, cleanup := writeSecretIdFile(t, wrapped.WrapInfo.Token)
+	defer cleanup()
+
+	v := newAppRoleAuthWithSecretIdFile(t, configuredVault, roleID, secretIDFile, true)
+
+	token := v.VaultClientOrPanic().Token()
+	if _, err := os.Stat(secretIDFile); !os.IsNotExist(err) {
+		t.Fatalf("expected secret id file to be removed after use")
+	}
+
+	// wait until the token is within the expiration window, so that the
+	// secret id unwrapped earlier is used to log in again
+	time.Sleep(time.Second * 3)
+
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatalf("expected to log in again with the unwrapped secret id, error: %s", err)
+	}
+	if client.Token() == token {
+		t.Fatalf("expected a new token once the old one is close to expiry")
+	}
+}
+
+func TestAppRoleAuthPullMode(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	config := newAppRolePullConfig(t, configuredVault)
+	config.AppRoleSecretIdMetadata = map[string]string{"deployment": "blue"}
+	config.AppRoleSecretIdCidrs = []string{"127.0.0.1/32"}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClientOrPanic().Logical().Write("secret/baz", map[string]interface{}{
+		"baz": "buzz",
+	}); err != nil {
+		t.Fatalf("could not write secret using authed client, error: %s", err)
+	}
+
+	accessors, err := configuredVault.rootClient.Logical().List("auth/approle/role/test1/secret-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessors == nil || len(accessors.Data["keys"].([]interface{})) != 1 {
+		t.Fatalf("expected one minted secret id")
+	}
+	lookup, err := configuredVault.rootClient.Logical().Write("auth/approle/role/test1/secret-id-accessor/lookup", map[string]interface{}{
+		"secret_id_accessor": accessors.Data["keys"].([]interface{})[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata := lookup.Data["metadata"].(map[string]interface{}); metadata["deployment"] != "blue" {
+		t.Fatalf("expected secret id metadata deployment=blue but got %v", metadata)
+	}
+	if cidrs := lookup.Data["cidr_list"].([]interface{}); len(cidrs) != 1 || cidrs[0] != "127.0.0.1/32" {
+		t.Fatalf("expected secret id bound to 127.0.0.1/32 but got %v", cidrs)
+	}
+}
+
+func TestAppRoleAuthPullModeMintsNewSecretIdWhenUsedUp(t *testing.T) {
+	// a token ttl inside the expiration window makes every call log in again
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1s", "1h")
+	defer destroy()
+
+	if _, err := configuredVault.rootClient.Logical().Write("auth/approle/role/test1", map[string]interface{}{
+		"secret_id_num_uses": 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Vault leaves the one use the role allows out of the response minting a
+	// secret id, so logins are recorded to catch any turned down for it
+	config := newAppRolePullConfig(t, configuredVault)
+	logins := &loginStatusRecorder{next: config.HttpClient.Transport}
+	config.HttpClient.Transport = logins
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := v.VaultClient(); err != nil {
+			t.Fatalf("expected login %d to mint a new secret id, error: %s", i+1, err)
+		}
+	}
+	if statuses := logins.recorded(); len(statuses) != 3 {
+		t.Fatalf("expected a new secret id to be minted ahead of each login but logins returned %v", statuses)
+	}
+}
+
+// loginStatusRecorder records the status of every login made through it.
+type loginStatusRecorder struct {
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	statuses []int
+}
+
+func (l *loginStatusRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := l.next.RoundTrip(r)
+	if err == nil && strings.HasSuffix(r.URL.Path, "/login") {
+		l.mu.Lock()
+		l.statuses = append(l.statuses, resp.StatusCode)
+		l.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (l *loginStatusRecorder) recorded() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]int(nil), l.statuses...)
+}
+
+// newAppRolePullConfig returns the config for AppRole pull mode, with a
+// bootstrap token that can only read the role id and generate secret ids.
+func newAppRolePullConfig(t *testing.T, configuredVault *configuredVault) *vaultclient.Config {
+	policy := `
+	path "auth/approle/role/test1/role-id" {
+		capabilities = ["read"]
+	}
+	path "auth/approle/role/test1/secret-id" {
+		capabilities = ["update"]
+	}
+	path "auth/approle/role/test1/secret-id/lookup" {
+		capabilities = ["update"]
+	}
+`
+	if err := configuredVault.rootClient.Sys().PutPolicy("bootstrap", policy); err != nil {
+		t.Fatal(err)
+	}
+	bootstrap, err := configuredVault.rootClient.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"bootstrap"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AppRole
+	config.AppRole = "test1"
+	config.AppRoleBootstrapToken = vaultclient.SecretFromString(bootstrap.Auth.ClientToken)
+
+	if err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func newAppRoleAuthWithSecretIdFile(t *testing.T, configuredVault *configuredVault, roleID, secretIDFile string, wrapped bool) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = roleID
+	config.AppRoleSecretIdFile = secretIDFile
+	config.AppRoleSecretIdWrapped = wrapped
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func writeSecretIdFile(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "secret-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
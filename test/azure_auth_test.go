@@ -0,0 +1,142 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureAuth(t *testing.T) {
+	imds := httptest.NewServer(newStandInImdsHandler(""))
+	defer imds.Close()
+
+	var login map[string]string
+	server := httptest.NewServer(newStandInAzureHandler(&login))
+	defer server.Close()
+
+	v, err := vaultclient.NewVaultAuth(newStandInAzureConfig(server.URL, imds.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "azure-token" {
+		t.Fatalf("expected token from azure login but got %s", client.Token())
+	}
+
+	expected := map[string]string{
+		"role":                "web",
+		"jwt":                 "managed-identity-token",
+		"subscription_id":     "subscription-1",
+		"resource_group_name": "group-1",
+		"vm_name":             "vm-1",
+	}
+	for key, value := range expected {
+		if login[key] != value {
+			t.Fatalf("expected login %s to be %s but was %s", key, value, login[key])
+		}
+	}
+	if _, found := login["vmss_name"]; found {
+		t.Fatalf("expected no vmss_name for a vm outside a scale set")
+	}
+}
+
+func TestAzureAuthOnScaleSet(t *testing.T) {
+	imds := httptest.NewServer(newStandInImdsHandler("scaleset-1"))
+	defer imds.Close()
+
+	var login map[string]string
+	server := httptest.NewServer(newStandInAzureHandler(&login))
+	defer server.Close()
+
+	v, err := vaultclient.NewVaultAuth(newStandInAzureConfig(server.URL, imds.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+	if login["vmss_name"] != "scaleset-1" {
+		t.Fatalf("expected vmss_name to be scaleset-1 but was %s", login["vmss_name"])
+	}
+}
+
+func TestAzureAuthWhenImdsFails(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "identity not found", http.StatusBadRequest)
+	}))
+	defer imds.Close()
+
+	v, err := vaultclient.NewVaultAuth(newStandInAzureConfig("http://127.0.0.1:0", imds.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.VaultClient()
+	if err == nil || !strings.Contains(err.Error(), "identity not found") {
+		t.Fatalf("expected imds error but got %v", err)
+	}
+}
+
+func newStandInAzureConfig(address, imdsEndpoint string) *vaultclient.Config {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Azure
+	config.AzureRole = "web"
+	config.AzureImdsEndpoint = imdsEndpoint
+	return config
+}
+
+// newStandInImdsHandler answers instance metadata and managed identity token
+// requests the way IMDS does for a vm, part of scaleSet when it is not empty.
+func newStandInImdsHandler(scaleSet string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, "missing metadata header", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/metadata/instance":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"compute": map[string]string{
+					"subscriptionId":    "subscription-1",
+					"resourceGroupName": "group-1",
+					"name":              "vm-1",
+					"vmScaleSetName":    scaleSet,
+				},
+			})
+		case "/metadata/identity/oauth2/token":
+			if r.URL.Query().Get("resource") != "https://management.azure.com/" {
+				http.Error(w, "unexpected resource", http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"access_token": "managed-identity-token",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// newStandInAzureHandler answers azure logins, keeping the login request in
+// login.
+func newStandInAzureHandler(login *map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/azure/login" {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(login); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeLoginResponse(w, "azure-token", 3600)
+	})
+}
@@ -0,0 +1,232 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	configuredVault, destroy := newVaultConfiguredForCert(t, ca.certPEM, "1h")
+	defer destroy()
+
+	certFile, keyFile, cleanup := ca.issueToFiles(t, "worker-1")
+	defer cleanup()
+
+	v := newCertAuth(t, configuredVault, certFile, keyFile, "workers")
+
+	if got := commonNameOfToken(t, v.VaultClientOrPanic()); got != "worker-1" {
+		t.Fatalf("expected token for worker-1 but was for %s", got)
+	}
+}
+
+func TestCertAuthPicksUpRotatedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	configuredVault, destroy := newVaultConfiguredForCert(t, ca.certPEM, "12s")
+	defer destroy()
+
+	certFile, keyFile, cleanup := ca.issueToFiles(t, "worker-1")
+	defer cleanup()
+
+	v := newCertAuth(t, configuredVault, certFile, keyFile, "")
+
+	if got := commonNameOfToken(t, v.VaultClientOrPanic()); got != "worker-1" {
+		t.Fatalf("expected token for worker-1 but was for %s", got)
+	}
+
+	// rotate the certificate in place
+	ca.issue(t, "worker-2", certFile, keyFile)
+
+	// wait until the token is within the expiration window
+	time.Sleep(time.Second * 3)
+
+	if got := commonNameOfToken(t, v.VaultClientOrPanic()); got != "worker-2" {
+		t.Fatalf("expected token for rotated certificate worker-2 but was for %s", got)
+	}
+}
+
+func TestCertAuthDoesNotShareCertificateThroughConfig(t *testing.T) {
+	ca := newTestCA(t)
+	configuredVault, destroy := newVaultConfiguredForCert(t, ca.certPEM, "1h")
+	defer destroy()
+
+	firstCert, firstKey, cleanup := ca.issueToFiles(t, "worker-1")
+	defer cleanup()
+	secondCert, secondKey, cleanup := ca.issueToFiles(t, "worker-2")
+	defer cleanup()
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Cert
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure:   true,
+		ClientCert: firstCert,
+		ClientKey:  firstKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second vault auth on the same config, and so the same transport, whose
+	// certificate would otherwise be presented by both
+	err = config.ConfigureTLS(&api.TLSConfig{
+		Insecure:   true,
+		ClientCert: secondCert,
+		ClientKey:  secondKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := commonNameOfToken(t, first.VaultClientOrPanic()); got != "worker-1" {
+		t.Fatalf("expected token for worker-1 but was for %s", got)
+	}
+	if got := commonNameOfToken(t, second.VaultClientOrPanic()); got != "worker-2" {
+		t.Fatalf("expected token for worker-2 but was for %s", got)
+	}
+}
+
+func TestCertAuthWithUntrustedCertificate(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForCert(t, newTestCA(t).certPEM, "1h")
+	defer destroy()
+
+	certFile, keyFile, cleanup := newTestCA(t).issueToFiles(t, "intruder")
+	defer cleanup()
+
+	v := newCertAuth(t, configuredVault, certFile, keyFile, "")
+
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected login with an untrusted certificate to fail")
+	}
+}
+
+func newCertAuth(t *testing.T, configuredVault *configuredVault, certFile, keyFile, role string) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Cert
+	config.CertRole = role
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure:   true,
+		ClientCert: certFile,
+		ClientKey:  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func commonNameOfToken(t *testing.T, client *api.Client) string {
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := secret.Data["meta"].(map[string]interface{})
+	commonName, _ := meta["common_name"].(string)
+	return commonName
+}
+
+// testCA issues client certificates for cert auth.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) issueToFiles(t *testing.T, commonName string) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "client-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ca.issue(t, commonName, certFile, keyFile)
+	return certFile, keyFile, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func (ca *testCA) issue(t *testing.T, commonName, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
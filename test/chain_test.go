@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestAuthChainFallsThroughToNextMethod(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+
+	config := newChainConfig(t, configuredVault, vaultclient.K8s, vaultclient.AppRole)
+	config.K8sRole = "test"
+	config.K8sPath = "kubernetes"
+	config.AppRoleId = roleID
+	config.AppRoleSecretId = secretID
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup, err := v.VaultClientOrPanic().Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatalf("expected approle login to succeed after k8s failed, error: %s", err)
+	}
+	if path := lookup.Data["path"]; path != "auth/approle/login" {
+		t.Fatalf("expected token from approle login but was from %s", path)
+	}
+}
+
+func TestAuthChainWithStaticTokenDoesNotRevokeIt(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	config := newChainConfig(t, configuredVault, vaultclient.AppRole, vaultclient.Token)
+	config.AppRoleId = "unknown"
+	config.AppRoleSecretId = "unknown"
+	config.Token = configuredVault.rootToken
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token := v.VaultClientOrPanic().Token(); token != configuredVault.rootToken {
+		t.Fatalf("expected the static token to be used")
+	}
+
+	if err := v.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configuredVault.rootClient.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected static token to survive close, error: %s", err)
+	}
+}
+
+func TestAuthChainWithAgentSinkRevokesTokensFromOtherMethods(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	sinkPath, cleanup := tempAgentSinkPath(t)
+	defer cleanup()
+	writeAgentSink(t, sinkPath, createToken(t, configuredVault, false).Auth.ClientToken)
+
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+	config := newChainConfig(t, configuredVault, vaultclient.AppRole, vaultclient.AgentSink)
+	config.AppRoleId = roleID
+	config.AppRoleSecretId = secretID
+	config.AgentSinkPath = sinkPath
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := v.VaultClientOrPanic().Token()
+	if err := v.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configuredVault.rootClient.Auth().Token().Lookup(token); err == nil {
+		t.Fatalf("expected approle token to be revoked on close")
+	}
+}
+
+func TestAuthChainWithAgentSinkSwapsTokenWrittenByAgent(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	sinkPath, cleanup := tempAgentSinkPath(t)
+	defer cleanup()
+	first := createToken(t, configuredVault, false).Auth.ClientToken
+	writeAgentSink(t, sinkPath, first)
+
+	config := newChainConfig(t, configuredVault, vaultclient.AppRole, vaultclient.AgentSink)
+	config.AppRoleId = "unknown"
+	config.AppRoleSecretId = "unknown"
+	config.AgentSinkPath = sinkPath
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := v.VaultClientOrPanic()
+	if client.Token() != first {
+		t.Fatalf("expected token from agent sink")
+	}
+
+	second := createToken(t, configuredVault, false).Auth.ClientToken
+	writeAgentSink(t, sinkPath, second)
+	retry.RunWith(&retry.Timer{Timeout: 10 * time.Second, Wait: 200 * time.Millisecond}, t, func(r *retry.R) {
+		if client.Token() != second {
+			r.Fatal("token not swapped yet")
+		}
+	})
+
+	if err := v.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configuredVault.rootClient.Auth().Token().Lookup(second); err != nil {
+		t.Fatalf("expected agent token to survive close, error: %s", err)
+	}
+}
+
+func TestAuthChainReportsEveryAttempt(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	config := newChainConfig(t, configuredVault, vaultclient.K8s, vaultclient.AppRole)
+	config.K8sRole = "test"
+	config.K8sPath = "kubernetes"
+	config.AppRoleId = "unknown"
+	config.AppRoleSecretId = "unknown"
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = v.VaultClient()
+	var chainErr *vaultclient.AuthChainError
+	if !errors.As(err, &chainErr) {
+		t.Fatalf("expected an auth chain error but got %v", err)
+	}
+	if len(chainErr.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts but got %d", len(chainErr.Attempts))
+	}
+	if chainErr.Attempts[0].AuthType != vaultclient.K8s || chainErr.Attempts[1].AuthType != vaultclient.AppRole {
+		t.Fatalf("expected attempts in chain order but got %s, %s", chainErr.Attempts[0].AuthType, chainErr.Attempts[1].AuthType)
+	}
+}
+
+func newChainConfig(t *testing.T, configuredVault *configuredVault, chain ...vaultclient.AuthType) *vaultclient.Config {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthChain = chain
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
@@ -18,6 +18,27 @@ func TestDefaultConfigWhenTokenSpecified(t *testing.T) {
 	}
 }
 
+func TestDefaultConfigWhenAppRoleBootstrapTokenSpecified(t *testing.T) {
+	defer setEnv("VAULT_APP_ROLE", "myservice")()
+	defer setEnv("VAULT_APP_ROLE_BOOTSTRAP_TOKEN", "s.bootstrap")()
+	defer setEnv("VAULT_APP_ROLE_PATH", "approle-deploy")()
+	config := vaultclient.NewDefaultConfig()
+
+	if config.AuthType != vaultclient.AppRole {
+		t.Fatalf("expected auth type to be approle")
+	}
+	if !strings.EqualFold("approle-deploy", config.AppRolePath) {
+		t.Fatalf("expected app role path to be approle-deploy but was %s", config.AppRolePath)
+	}
+	if config.AppRoleBootstrapToken == nil {
+		t.Fatalf("expected app role bootstrap token to be set")
+	}
+	token, err := config.AppRoleBootstrapToken()
+	if err != nil || token != "s.bootstrap" {
+		t.Fatalf("expected app role bootstrap token s.bootstrap but got %s (%v)", token, err)
+	}
+}
+
 func TestDefaultConfigWhenIamRoleSpecified(t *testing.T) {
 	defer setEnv("VAULT_ROLE", "foo")()
 	config := vaultclient.NewDefaultConfig()
@@ -30,6 +51,30 @@ func TestDefaultConfigWhenIamRoleSpecified(t *testing.T) {
 	}
 }
 
+func TestDefaultConfigWhenAwsPathAndIamServerIdHeaderSpecified(t *testing.T) {
+	defer setEnv("VAULT_ROLE", "foo")()
+	defer setEnv("VAULT_AWS_PATH", "aws-myaccount")()
+	defer setEnv("VAULT_IAM_SERVER_ID_HEADER", "vault.example.com")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("aws-myaccount", config.AwsPath) {
+		t.Fatalf("expected aws path to be aws-myaccount but was %s", config.AwsPath)
+	}
+	if !strings.EqualFold("vault.example.com", config.IamServerIdHeader) {
+		t.Fatalf("expected iam server id header to be vault.example.com but was %s", config.IamServerIdHeader)
+	}
+}
+
+func TestDefaultConfigWhenIamEndpointStrategySpecified(t *testing.T) {
+	defer setEnv("VAULT_ROLE", "foo")()
+	defer setEnv("VAULT_IAM_ENDPOINT_STRATEGY", "regional")()
+	config := vaultclient.NewDefaultConfig()
+
+	if config.IamEndpointStrategy != vaultclient.IamEndpointRegional {
+		t.Fatalf("expected iam endpoint strategy to be regional but was %s", config.IamEndpointStrategy)
+	}
+}
+
 func TestDefaultConfigWhenK8sRoleSpecified(t *testing.T) {
 	defer setEnv("K8S_ROLE", "greatservice")()
 	config := vaultclient.NewDefaultConfig()
@@ -47,6 +92,21 @@ func TestDefaultConfigWhenK8sRoleSpecified(t *testing.T) {
 	}
 }
 
+func TestDefaultConfigWhenK8sTokenPathAndAudienceSpecified(t *testing.T) {
+	defer setEnv("K8S_ROLE", "myservice")()
+	defer setEnv("K8S_TOKEN_PATH", "/var/run/secrets/tokens/vault-token")()
+	defer setEnv("K8S_AUDIENCE", "vault")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("/var/run/secrets/tokens/vault-token", config.K8sTokenPath) {
+		t.Fatalf("expected k8s token path /var/run/secrets/tokens/vault-token but got %s", config.K8sTokenPath)
+	}
+
+	if !strings.EqualFold("vault", config.K8sAudience) {
+		t.Fatalf("expected k8s audience vault but got %s", config.K8sAudience)
+	}
+}
+
 func TestDefaultConfigWhenK8sRoleAndPathSpecified(t *testing.T) {
 	defer setEnv("K8S_ROLE", "myservice")()
 	defer setEnv("K8S_PATH", "kubernetes")()
@@ -84,3 +144,171 @@ func TestDefaultConfigWhenAppRoleSpecified(t *testing.T) {
 		t.Fatalf("expected auth type to be AppRole")
 	}
 }
+
+func TestDefaultConfigWhenUserpassSpecified(t *testing.T) {
+	defer setEnv("VAULT_USERPASS_USERNAME", "jane")()
+	defer setEnv("VAULT_USERPASS_PASSWORD", "hunter2")()
+	defer setEnv("VAULT_USERPASS_PATH", "people")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("jane", config.UserpassUsername) {
+		t.Fatalf("expected userpass username to be jane but was %s", config.UserpassUsername)
+	}
+	if !strings.EqualFold("hunter2", config.UserpassPassword) {
+		t.Fatalf("expected userpass password to be hunter2 but was %s", config.UserpassPassword)
+	}
+	if !strings.EqualFold("people", config.UserpassPath) {
+		t.Fatalf("expected userpass path to be people but was %s", config.UserpassPath)
+	}
+	if config.AuthType != vaultclient.Userpass {
+		t.Fatalf("expected auth type to be Userpass")
+	}
+}
+
+func TestDefaultConfigWhenLdapSpecified(t *testing.T) {
+	defer setEnv("VAULT_LDAP_USERNAME", "jane")()
+	defer setEnv("VAULT_LDAP_PASSWORD", "hunter2")()
+	defer setEnv("VAULT_LDAP_PATH", "corp-ldap")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("jane", config.LdapUsername) {
+		t.Fatalf("expected ldap username to be jane but was %s", config.LdapUsername)
+	}
+	if !strings.EqualFold("corp-ldap", config.LdapPath) {
+		t.Fatalf("expected ldap path to be corp-ldap but was %s", config.LdapPath)
+	}
+	if config.LdapPassword == nil {
+		t.Fatalf("expected ldap password source to be set")
+	}
+	password, err := config.LdapPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "hunter2" {
+		t.Fatalf("expected ldap password to be hunter2 but was %s", password)
+	}
+	if config.AuthType != vaultclient.Ldap {
+		t.Fatalf("expected auth type to be Ldap")
+	}
+}
+
+func TestDefaultConfigWhenJwtSpecified(t *testing.T) {
+	defer setEnv("VAULT_JWT_ROLE", "ci")()
+	defer setEnv("VAULT_JWT", "header.payload.signature")()
+	defer setEnv("VAULT_JWT_PATH", "gitlab")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("ci", config.JwtRole) {
+		t.Fatalf("expected jwt role to be ci but was %s", config.JwtRole)
+	}
+	if !strings.EqualFold("gitlab", config.JwtPath) {
+		t.Fatalf("expected jwt path to be gitlab but was %s", config.JwtPath)
+	}
+	if config.Jwt == nil {
+		t.Fatalf("expected jwt source to be set")
+	}
+	jwt, err := config.Jwt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt != "header.payload.signature" {
+		t.Fatalf("expected jwt to be header.payload.signature but was %s", jwt)
+	}
+	if config.AuthType != vaultclient.Jwt {
+		t.Fatalf("expected auth type to be Jwt")
+	}
+}
+
+func TestDefaultConfigWhenGcpRoleSpecified(t *testing.T) {
+	defer setEnv("VAULT_GCP_ROLE", "web")()
+	defer setEnv("VAULT_GCP_ROLE_TYPE", "gce")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("web", config.GcpRole) {
+		t.Fatalf("expected gcp role to be web but was %s", config.GcpRole)
+	}
+	if config.GcpRoleType != vaultclient.GcpRoleTypeGce {
+		t.Fatalf("expected gcp role type to be gce but was %s", config.GcpRoleType)
+	}
+	if config.AuthType != vaultclient.Gcp {
+		t.Fatalf("expected auth type to be Gcp")
+	}
+}
+
+func TestDefaultConfigWhenAzureRoleSpecified(t *testing.T) {
+	defer setEnv("VAULT_AZURE_ROLE", "web")()
+	defer setEnv("VAULT_AZURE_PATH", "azure-prod")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("web", config.AzureRole) {
+		t.Fatalf("expected azure role to be web but was %s", config.AzureRole)
+	}
+	if !strings.EqualFold("azure-prod", config.AzurePath) {
+		t.Fatalf("expected azure path to be azure-prod but was %s", config.AzurePath)
+	}
+	if config.AuthType != vaultclient.Azure {
+		t.Fatalf("expected auth type to be Azure")
+	}
+}
+
+func TestDefaultConfigWhenEc2RoleSpecified(t *testing.T) {
+	defer setEnv("VAULT_EC2_ROLE", "legacy")()
+	defer setEnv("VAULT_EC2_NONCE_FILE", "/var/lib/myservice/nonce")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("legacy", config.Ec2Role) {
+		t.Fatalf("expected ec2 role to be legacy but was %s", config.Ec2Role)
+	}
+	if !strings.EqualFold("/var/lib/myservice/nonce", config.Ec2NonceFile) {
+		t.Fatalf("expected ec2 nonce file to be /var/lib/myservice/nonce but was %s", config.Ec2NonceFile)
+	}
+	if config.AuthType != vaultclient.Ec2 {
+		t.Fatalf("expected auth type to be Ec2")
+	}
+}
+
+func TestDefaultConfigWhenAgentSinkSpecified(t *testing.T) {
+	defer setEnv("VAULT_AGENT_SINK_PATH", "/var/run/vault/token")()
+	defer setEnv("VAULT_AGENT_SINK_WRAPPED", "true")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("/var/run/vault/token", config.AgentSinkPath) {
+		t.Fatalf("expected agent sink path to be /var/run/vault/token but was %s", config.AgentSinkPath)
+	}
+	if !config.AgentSinkWrapped {
+		t.Fatalf("expected agent sink to be wrapped")
+	}
+	if config.AuthType != vaultclient.AgentSink {
+		t.Fatalf("expected auth type to be AgentSink")
+	}
+}
+
+func TestDefaultConfigWhenAppRoleSecretIdFileSpecified(t *testing.T) {
+	defer setEnv("VAULT_APP_ROLE", "testrole")()
+	defer setEnv("VAULT_APP_ROLE_ID", "myroleid")()
+	defer setEnv("VAULT_APP_SECRET_ID_FILE", "/run/secrets/secret-id")()
+	defer setEnv("VAULT_APP_SECRET_ID_WRAPPED", "true")()
+	config := vaultclient.NewDefaultConfig()
+
+	if !strings.EqualFold("/run/secrets/secret-id", config.AppRoleSecretIdFile) {
+		t.Fatalf("expected app role secret id file to be /run/secrets/secret-id but was %s", config.AppRoleSecretIdFile)
+	}
+	if !config.AppRoleSecretIdWrapped {
+		t.Fatalf("expected app role secret id to be wrapped")
+	}
+	if config.AuthType != vaultclient.AppRole {
+		t.Fatalf("expected auth type to be AppRole")
+	}
+}
+
+func TestDefaultConfigWhenTokenCachePathSpecifiedWithoutKey(t *testing.T) {
+	defer setEnv("VAULT_TOKEN", "s.token")()
+	defer setEnv("VAULT_TOKEN_CACHE_PATH", "/tmp/token")()
+	defer setEnv("VAULT_TOKEN_CACHE_KEY", "")()
+	defer setEnv("VAULT_TOKEN_CACHE_KEY_FILE", "")()
+	config := vaultclient.NewDefaultConfig()
+
+	if config.Error == nil {
+		t.Fatalf("expected a token cache path without a key to be a configuration error")
+	}
+}
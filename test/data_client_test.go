@@ -13,12 +13,19 @@ func TestConfigure(t *testing.T) {
 	vault, deferFunc := newVault(t)
 	defer deferFunc()
 
-	cfg := &vaultclient.Config{
-		AuthType: vaultclient.Token,
-		Token:    vault.rootToken,
+	cfg := vaultclient.BaseConfig()
+	cfg.Address = vault.address
+	cfg.AuthType = vaultclient.Token
+	cfg.Token = vault.rootToken
+
+	err := cfg.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	err := vaultclient.Configure(cfg)
+	err = vaultclient.Configure(cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
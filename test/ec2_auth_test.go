@@ -0,0 +1,218 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEc2AuthWithImdsV2(t *testing.T) {
+	imds := httptest.NewServer(newStandInEc2ImdsHandler(true))
+	defer imds.Close()
+
+	var nonces []string
+	server := httptest.NewServer(newStandInEc2Handler("aws", &nonces))
+	defer server.Close()
+
+	v, err := vaultclient.NewVaultAuth(newStandInEc2Config(server.URL, imds.URL, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "ec2-token" {
+		t.Fatalf("expected token from ec2 login but got %s", client.Token())
+	}
+}
+
+func TestEc2AuthFallsBackToImdsV1(t *testing.T) {
+	imds := httptest.NewServer(newStandInEc2ImdsHandler(false))
+	defer imds.Close()
+
+	var nonces []string
+	server := httptest.NewServer(newStandInEc2Handler("aws", &nonces))
+	defer server.Close()
+
+	v, err := vaultclient.NewVaultAuth(newStandInEc2Config(server.URL, imds.URL, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEc2AuthPersistsNonce(t *testing.T) {
+	imds := httptest.NewServer(newStandInEc2ImdsHandler(true))
+	defer imds.Close()
+
+	var nonces []string
+	server := httptest.NewServer(newStandInEc2Handler("aws", &nonces))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ec2-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nonceFile := filepath.Join(dir, "nonce")
+
+	for i := 0; i < 2; i++ {
+		// a new vault auth each time, as after a restart
+		v, err := vaultclient.NewVaultAuth(newStandInEc2Config(server.URL, imds.URL, nonceFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.VaultClient(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(nonces) != 2 || nonces[0] == "" || nonces[0] != nonces[1] {
+		t.Fatalf("expected the same nonce to be used for both logins but got %v", nonces)
+	}
+	persisted, err := ioutil.ReadFile(nonceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(persisted) != nonces[0] {
+		t.Fatalf("expected nonce %s to be persisted but found %s", nonces[0], persisted)
+	}
+}
+
+func TestEc2AuthRetriesSavingNonce(t *testing.T) {
+	imds := httptest.NewServer(newStandInEc2ImdsHandler(true))
+	defer imds.Close()
+
+	var nonces []string
+	server := httptest.NewServer(newStandInEc2Handler("aws", &nonces))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ec2-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the nonce file links into a directory that does not exist yet, so the
+	// nonce cannot be saved until it is created
+	target := filepath.Join(dir, "state")
+	nonceFile := filepath.Join(dir, "nonce")
+	if err := os.Symlink(filepath.Join(target, "nonce"), nonceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(newStandInEc2Config(server.URL, imds.URL, nonceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatal("expected login to fail while the nonce cannot be saved")
+	}
+	if len(nonces) != 0 {
+		t.Fatalf("expected no login with an unsaved nonce but got %v", nonces)
+	}
+
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+	persisted, err := ioutil.ReadFile(nonceFile)
+	if err != nil {
+		t.Fatalf("expected nonce to be saved on the next login, error: %s", err)
+	}
+	if len(nonces) != 1 || string(persisted) != nonces[0] {
+		t.Fatalf("expected nonce %v to be persisted but found %s", nonces, persisted)
+	}
+}
+
+func TestEc2AuthWithAwsPath(t *testing.T) {
+	imds := httptest.NewServer(newStandInEc2ImdsHandler(true))
+	defer imds.Close()
+
+	var nonces []string
+	server := httptest.NewServer(newStandInEc2Handler("aws-myaccount", &nonces))
+	defer server.Close()
+
+	config := newStandInEc2Config(server.URL, imds.URL, "")
+	config.AwsPath = "aws-myaccount"
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+	if len(nonces) != 1 {
+		t.Fatalf("expected a login against the aws-myaccount mount")
+	}
+}
+
+func newStandInEc2Config(address, imdsEndpoint, nonceFile string) *vaultclient.Config {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Ec2
+	config.Ec2Role = "legacy"
+	config.Ec2ImdsEndpoint = imdsEndpoint
+	config.Ec2NonceFile = nonceFile
+	return config
+}
+
+// newStandInEc2ImdsHandler serves the pkcs7 identity document, requiring an
+// IMDSv2 session token when v2 is set and refusing to hand one out otherwise.
+func newStandInEc2ImdsHandler(v2 bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			if !v2 {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			if r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds") == "" {
+				http.Error(w, "missing ttl", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte("imds-session-token"))
+		case r.Method == "GET" && r.URL.Path == "/latest/dynamic/instance-identity/pkcs7":
+			if v2 && r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-session-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte("MIAGCSqGSIb3\nDQEHAqCAMIAC\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// newStandInEc2Handler answers ec2 logins on the given mount, recording the
+// nonce of each login in nonces.
+func newStandInEc2Handler(mount string, nonces *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/"+mount+"/login" {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["role"] != "legacy" || body["pkcs7"] != "MIAGCSqGSIb3DQEHAqCAMIAC" || strings.TrimSpace(body["nonce"]) == "" {
+			writeErrorResponse(w, http.StatusBadRequest, "client nonce mismatch")
+			return
+		}
+		*nonces = append(*nonces, body["nonce"])
+		writeLoginResponse(w, "ec2-token", 3600)
+	})
+}
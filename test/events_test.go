@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+)
+
+func TestTokenEventsOnLoginAndRenewal(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "3s", "1h")
+	defer destroy()
+
+	v := newAppRoleAuthWithBackgroundRenewal(t, configuredVault)
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+
+	events := make(chan vaultclient.TokenEvent, 10)
+	unsubscribe := v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})
+	defer unsubscribe()
+
+	client := v.VaultClientOrPanic()
+
+	rotated := nextTokenEvent(t, events)
+	if rotated.Type != vaultclient.TokenRotated {
+		t.Fatalf("expected token rotated event but got %d", rotated.Type)
+	}
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Accessor != lookup.Data["accessor"] {
+		t.Fatalf("expected accessor %s but was %s", lookup.Data["accessor"], rotated.Accessor)
+	}
+	if rotated.TTL != 3*time.Second {
+		t.Fatalf("expected ttl of 3s but was %s", rotated.TTL)
+	}
+	if rotated.Expiry.IsZero() {
+		t.Fatalf("expected expiry to be set")
+	}
+
+	renewed := nextTokenEvent(t, events)
+	if renewed.Type != vaultclient.TokenRenewed {
+		t.Fatalf("expected token renewed event but got %d", renewed.Type)
+	}
+	if renewed.Accessor != rotated.Accessor {
+		t.Fatalf("expected renewal to keep accessor %s but was %s", rotated.Accessor, renewed.Accessor)
+	}
+}
+
+func TestTokenEventsOnLoginFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorResponse(w, http.StatusForbidden, "permission denied")
+	}))
+	defer server.Close()
+
+	v := newStandInAppRoleAuth(t, server.URL)
+
+	events := make(chan vaultclient.TokenEvent, 10)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})()
+
+	_, loginErr := v.VaultClient()
+	if loginErr == nil {
+		t.Fatalf("expected login to fail")
+	}
+
+	failed := nextTokenEvent(t, events)
+	if failed.Type != vaultclient.LoginFailed {
+		t.Fatalf("expected login failed event but got %d", failed.Type)
+	}
+	if failed.Err != loginErr {
+		t.Fatalf("expected event to carry the login error %v but was %v", loginErr, failed.Err)
+	}
+}
+
+func TestTokenEventsLoginFailedSubscriberCanAskForClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorResponse(w, http.StatusForbidden, "permission denied")
+	}))
+	defer server.Close()
+
+	v := newStandInAppRoleAuth(t, server.URL)
+
+	var retried int32
+	retryErrs := make(chan error, 1)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		// retry once from the subscriber, as a caller reacting to the failure
+		// would
+		if event.Type == vaultclient.LoginFailed && atomic.CompareAndSwapInt32(&retried, 0, 1) {
+			_, err := v.VaultClient()
+			retryErrs <- err
+		}
+	})()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.VaultClient()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected login to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the login, the subscriber is deadlocked")
+	}
+	if err := <-retryErrs; err == nil {
+		t.Fatalf("expected login from the subscriber to fail")
+	}
+}
+
+func nextTokenEvent(t *testing.T, events <-chan vaultclient.TokenEvent) vaultclient.TokenEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for token event")
+		return vaultclient.TokenEvent{}
+	}
+}
@@ -0,0 +1,192 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGcpIamAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile, cleanup := writeGcpCredentials(t, key)
+	defer cleanup()
+
+	server := httptest.NewServer(newStandInGcpHandler(func(jwt string) bool {
+		claims, ok := verifyRS256(jwt, &key.PublicKey)
+		return ok && claims["sub"] == "vault@project.iam.gserviceaccount.com" && claims["aud"] == "vault/web"
+	}))
+	defer server.Close()
+
+	config := newStandInGcpConfig(server.URL)
+	config.GcpRoleType = vaultclient.GcpRoleTypeIam
+	config.GcpCredentialsFile = credentialsFile
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "gcp-token" {
+		t.Fatalf("expected token from gcp login but got %s", client.Token())
+	}
+}
+
+func TestGcpGceAuth(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" ||
+			r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" ||
+			r.URL.Query().Get("audience") != "http://vault/web" ||
+			r.URL.Query().Get("format") != "full" {
+			http.Error(w, "bad metadata request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("instance-identity-token"))
+	}))
+	defer metadata.Close()
+
+	server := httptest.NewServer(newStandInGcpHandler(func(jwt string) bool {
+		return jwt == "instance-identity-token"
+	}))
+	defer server.Close()
+
+	config := newStandInGcpConfig(server.URL)
+	config.GcpRoleType = vaultclient.GcpRoleTypeGce
+	config.GcpMetadataEndpoint = metadata.URL
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGcpGceAuthWhenMetadataServerFails(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not on gce", http.StatusNotFound)
+	}))
+	defer metadata.Close()
+
+	config := newStandInGcpConfig("http://127.0.0.1:0")
+	config.GcpRoleType = vaultclient.GcpRoleTypeGce
+	config.GcpMetadataEndpoint = metadata.URL
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.VaultClient()
+	if err == nil || !strings.Contains(err.Error(), "not on gce") {
+		t.Fatalf("expected metadata server error but got %v", err)
+	}
+}
+
+func TestGcpAuthWithUnknownRoleType(t *testing.T) {
+	config := newStandInGcpConfig("http://127.0.0.1:0")
+	config.GcpRoleType = "gke"
+
+	if _, err := vaultclient.NewVaultAuth(config); err == nil {
+		t.Fatalf("expected unknown gcp role type to fail")
+	}
+}
+
+func newStandInGcpConfig(address string) *vaultclient.Config {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Gcp
+	config.GcpRole = "web"
+	return config
+}
+
+// newStandInGcpHandler answers gcp logins for the web role, using valid to
+// check the jwt.
+func newStandInGcpHandler(valid func(jwt string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/gcp/login" {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["role"] != "web" || !valid(body["jwt"]) {
+			writeErrorResponse(w, http.StatusBadRequest, "unable to verify jwt")
+			return
+		}
+		writeLoginResponse(w, "gcp-token", 3600)
+	})
+}
+
+func writeGcpCredentials(t *testing.T, key *rsa.PrivateKey) (string, func()) {
+	dir, err := ioutil.TempDir("", "gcp-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "vault@project.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, credentials, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// verifyRS256 checks the signature of jwt and returns its claims.
+func verifyRS256(jwt string, key *rsa.PublicKey) (map[string]interface{}, bool) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
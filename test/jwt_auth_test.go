@@ -0,0 +1,129 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJwtAuth(t *testing.T) {
+	server := httptest.NewServer(newStandInJwtHandler("jwt", "ci", 3600))
+	defer server.Close()
+
+	v := newStandInJwtAuth(t, server.URL, "", vaultclient.SecretFromString("jwt-1"))
+
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "token-for-jwt-1" {
+		t.Fatalf("expected token from jwt login but got %s", client.Token())
+	}
+}
+
+func TestJwtAuthRereadsJwtFileOnEachLogin(t *testing.T) {
+	// a ttl inside the expiration window makes every call log in again
+	server := httptest.NewServer(newStandInJwtHandler("gitlab", "ci", 1))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwtFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(jwtFile, []byte("jwt-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newStandInJwtAuth(t, server.URL, "gitlab", vaultclient.SecretFromFile(jwtFile))
+
+	if token := v.VaultClientOrPanic().Token(); token != "token-for-jwt-1" {
+		t.Fatalf("expected token for first jwt but got %s", token)
+	}
+
+	if err := ioutil.WriteFile(jwtFile, []byte("jwt-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if token := v.VaultClientOrPanic().Token(); token != "token-for-jwt-2" {
+		t.Fatalf("expected token for rotated jwt but got %s", token)
+	}
+}
+
+func TestJwtAuthWithJwtFromCallback(t *testing.T) {
+	server := httptest.NewServer(newStandInJwtHandler("jwt", "ci", 3600))
+	defer server.Close()
+
+	calls := 0
+	v := newStandInJwtAuth(t, server.URL, "", func() (string, error) {
+		calls++
+		return "jwt-from-callback", nil
+	})
+
+	if token := v.VaultClientOrPanic().Token(); token != "token-for-jwt-from-callback" {
+		t.Fatalf("expected token for callback jwt but got %s", token)
+	}
+	if calls != 1 {
+		t.Fatalf("expected jwt callback to be called once but was called %d times", calls)
+	}
+}
+
+func TestJwtAuthWithWrongRole(t *testing.T) {
+	server := httptest.NewServer(newStandInJwtHandler("jwt", "deploy", 3600))
+	defer server.Close()
+
+	v := newStandInJwtAuth(t, server.URL, "", vaultclient.SecretFromString("jwt-1"))
+
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected login with the wrong role to fail")
+	}
+}
+
+// newStandInJwtHandler answers jwt logins for role on the given mount with a
+// token derived from the jwt.
+func newStandInJwtHandler(mount, role string, ttl int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/"+mount+"/login" {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["role"] != role {
+			writeErrorResponse(w, http.StatusBadRequest, "role could not be found")
+			return
+		}
+		if !strings.HasPrefix(body["jwt"], "jwt-") {
+			writeErrorResponse(w, http.StatusBadRequest, "error validating token")
+			return
+		}
+		writeLoginResponse(w, "token-for-"+body["jwt"], ttl)
+	})
+}
+
+func newStandInJwtAuth(t *testing.T, address, path string, jwt vaultclient.SecretSource) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Jwt
+	config.JwtRole = "ci"
+	config.Jwt = jwt
+	config.JwtPath = path
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
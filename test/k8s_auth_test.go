@@ -0,0 +1,159 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestK8sAuth(t *testing.T) {
+	var jwts []string
+	server := httptest.NewServer(newStandInK8sHandler("k8s-myservice", 3600, &jwts))
+	defer server.Close()
+
+	tokenPath, cleanup := tempServiceAccountTokenPath(t)
+	defer cleanup()
+	jwt := writeServiceAccountToken(t, tokenPath, "vault")
+
+	v, err := vaultclient.NewVaultAuth(newStandInK8sConfig(server.URL, tokenPath, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "k8s-token" {
+		t.Fatalf("expected token from k8s login but got %s", client.Token())
+	}
+	if len(jwts) != 1 || jwts[0] != jwt {
+		t.Fatalf("expected login with the service account token but got %v", jwts)
+	}
+}
+
+func TestK8sAuthPicksUpRotatedToken(t *testing.T) {
+	// a ttl inside the expiration window makes every call log in again
+	var jwts []string
+	server := httptest.NewServer(newStandInK8sHandler("k8s-myservice", 1, &jwts))
+	defer server.Close()
+
+	tokenPath, cleanup := tempServiceAccountTokenPath(t)
+	defer cleanup()
+	first := writeServiceAccountToken(t, tokenPath, "vault")
+
+	v, err := vaultclient.NewVaultAuth(newStandInK8sConfig(server.URL, tokenPath, "vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.VaultClientOrPanic()
+	v.VaultClientOrPanic()
+
+	// the kubelet rotates the projected token
+	second := writeServiceAccountToken(t, tokenPath, "vault")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	v.VaultClientOrPanic()
+
+	expected := []string{first, first, second}
+	if strings.Join(jwts, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected logins with %v but got %v", expected, jwts)
+	}
+}
+
+func TestK8sAuthWithTokenForOtherAudience(t *testing.T) {
+	var jwts []string
+	server := httptest.NewServer(newStandInK8sHandler("k8s-myservice", 3600, &jwts))
+	defer server.Close()
+
+	tokenPath, cleanup := tempServiceAccountTokenPath(t)
+	defer cleanup()
+	writeServiceAccountToken(t, tokenPath, "https://kubernetes.default.svc")
+
+	v, err := vaultclient.NewVaultAuth(newStandInK8sConfig(server.URL, tokenPath, "vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.VaultClient()
+	if err == nil || !strings.Contains(err.Error(), "audience 'vault'") {
+		t.Fatalf("expected audience error but got %v", err)
+	}
+	if len(jwts) != 0 {
+		t.Fatalf("expected no login with a token for another audience")
+	}
+}
+
+func newStandInK8sConfig(address, tokenPath, audience string) *vaultclient.Config {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.K8s
+	config.K8sRole = "myservice"
+	config.K8sPath = "k8s-myservice"
+	config.K8sTokenPath = tokenPath
+	config.K8sAudience = audience
+	return config
+}
+
+// newStandInK8sHandler answers kubernetes logins on the given mount,
+// recording the jwt of each login in jwts.
+func newStandInK8sHandler(mount string, ttl int, jwts *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/"+mount+"/login" {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["role"] != "myservice" || body["jwt"] == "" {
+			writeErrorResponse(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		*jwts = append(*jwts, body["jwt"])
+		writeLoginResponse(w, "k8s-token", ttl)
+	})
+}
+
+func tempServiceAccountTokenPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "token"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// writeServiceAccountToken writes a projected service account token bound to
+// audience. The signature is not checked by the stand-in.
+func writeServiceAccountToken(t *testing.T, path, audience string) string {
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": []string{audience},
+		"sub": "system:serviceaccount:default:myservice",
+		"iat": time.Now().UnixNano(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)),
+		base64.RawURLEncoding.EncodeToString(claims),
+		"signature",
+	}, ".")
+	if err := ioutil.WriteFile(path, []byte(jwt), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return jwt
+}
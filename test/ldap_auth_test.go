@@ -0,0 +1,149 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLdapAuth(t *testing.T) {
+	server := httptest.NewServer(newStandInLdapHandler("ldap", "jane", "hunter2"))
+	defer server.Close()
+
+	v := newStandInLdapAuth(t, server.URL, "", vaultclient.SecretFromString("hunter2"))
+
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() != "ldap-token" {
+		t.Fatalf("expected token from ldap login but got %s", client.Token())
+	}
+}
+
+func TestLdapAuthWithCustomMountAndPasswordFromFile(t *testing.T) {
+	server := httptest.NewServer(newStandInLdapHandler("corp-ldap", "jane", "hunter2"))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ldap-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newStandInLdapAuth(t, server.URL, "corp-ldap", vaultclient.SecretFromFile(passwordFile))
+
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLdapAuthWithPasswordFromEnv(t *testing.T) {
+	server := httptest.NewServer(newStandInLdapHandler("ldap", "jane", "hunter2"))
+	defer server.Close()
+	defer setEnv("TEST_LDAP_PASSWORD", "hunter2")()
+
+	v := newStandInLdapAuth(t, server.URL, "", vaultclient.SecretFromEnv("TEST_LDAP_PASSWORD"))
+
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLdapAuthWithWrongPassword(t *testing.T) {
+	server := httptest.NewServer(newStandInLdapHandler("ldap", "jane", "hunter2"))
+	defer server.Close()
+
+	v := newStandInLdapAuth(t, server.URL, "", vaultclient.SecretFromString("wrong"))
+
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected login with the wrong password to fail")
+	}
+}
+
+func TestLdapAuthRequiresPasswordSource(t *testing.T) {
+	config := vaultclient.BaseConfig()
+	config.AuthType = vaultclient.Ldap
+	config.LdapUsername = "jane"
+
+	if _, err := vaultclient.NewVaultAuth(config); err == nil {
+		t.Fatalf("expected ldap auth without a password source to fail")
+	}
+}
+
+func TestLdapAuthEscapesUsername(t *testing.T) {
+	server := httptest.NewServer(newStandInLdapHandler("ldap", "cn=jane%2Fdoe%3F", "hunter2"))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Ldap
+	config.LdapUsername = "cn=jane/doe?"
+	config.LdapPassword = vaultclient.SecretFromString("hunter2")
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatalf("expected login with the escaped username to succeed, error: %s", err)
+	}
+}
+
+func TestLdapAuthRequiresUsername(t *testing.T) {
+	config := vaultclient.BaseConfig()
+	config.AuthType = vaultclient.Ldap
+	config.LdapPassword = vaultclient.SecretFromString("hunter2")
+
+	if _, err := vaultclient.NewVaultAuth(config); err == nil {
+		t.Fatalf("expected ldap auth without a username to fail")
+	}
+}
+
+// newStandInLdapHandler answers ldap logins for a single user on the given
+// mount, with username as it is escaped in the path.
+func newStandInLdapHandler(mount, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/v1/auth/"+mount+"/login/"+username {
+			writeErrorResponse(w, http.StatusNotFound, "no handler for route")
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["password"] != password {
+			writeErrorResponse(w, http.StatusBadRequest, "ldap operation failed")
+			return
+		}
+		writeLoginResponse(w, "ldap-token", 3600)
+	})
+}
+
+func newStandInLdapAuth(t *testing.T, address, path string, password vaultclient.SecretSource) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Ldap
+	config.LdapUsername = "jane"
+	config.LdapPassword = password
+	config.LdapPath = path
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
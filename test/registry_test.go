@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"github.com/hashicorp/vault/api"
+)
+
+const customAuthType = vaultclient.CustomAuthType
+
+type customAuth struct {
+	name string
+}
+
+func (c *customAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	return client.Logical().Write("auth/custom/login/"+c.name, nil)
+}
+
+func TestRegisteredAuthMethod(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/custom/login/myservice" {
+			writeErrorResponse(w, http.StatusNotFound, "unexpected path "+r.URL.Path)
+			return
+		}
+		writeLoginResponse(w, "s.custom", 3600)
+	}))
+	defer server.Close()
+
+	err := vaultclient.RegisterAuthMethod(customAuthType, func(cfg *vaultclient.Config) (vaultclient.AuthMethod, error) {
+		return &customAuth{name: "myservice"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vaultclient.RegisterAuthMethod(customAuthType, nil); err == nil {
+		t.Fatalf("expected registering an auth type twice to fail")
+	}
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.AuthType = customAuthType
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token := v.VaultClientOrPanic().Token(); token != "s.custom" {
+		t.Fatalf("expected token s.custom but was %s", token)
+	}
+}
+
+func TestVaultAuthWithMethod(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeLoginResponse(w, "s.direct", 3600)
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+
+	v, err := vaultclient.NewVaultAuthWithMethod(config, &customAuth{name: "direct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token := v.VaultClientOrPanic().Token(); token != "s.direct" {
+		t.Fatalf("expected token s.direct but was %s", token)
+	}
+}
+
+func TestVaultAuthWithMethodImplementsOptionalInterfaces(t *testing.T) {
+	v, err := vaultclient.NewVaultAuthWithMethod(vaultclient.BaseConfig(), &customAuth{name: "direct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := v.(vaultclient.VaultAuthContext); !ok {
+		t.Fatalf("expected vault auth to implement VaultAuthContext")
+	}
+	if _, ok := v.(vaultclient.ClosableVaultAuth); !ok {
+		t.Fatalf("expected vault auth to implement ClosableVaultAuth")
+	}
+	if _, ok := v.(vaultclient.SubscribableVaultAuth); !ok {
+		t.Fatalf("expected vault auth to implement SubscribableVaultAuth")
+	}
+}
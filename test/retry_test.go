@@ -0,0 +1,121 @@
+package test
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	cases := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"connection refused":  {&url.Error{Op: "Put", URL: "http://vault", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}}, true},
+		"connection reset":    {&url.Error{Op: "Put", URL: "http://vault", Err: &net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}}, true},
+		"unknown host":        {&url.Error{Op: "Put", URL: "http://vault", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "vault"}}}, true},
+		"timeout":             {&url.Error{Op: "Put", URL: "http://vault", Err: &net.DNSError{Err: "i/o timeout", Name: "vault", IsTimeout: true}}, true},
+		"unsupported scheme":  {&url.Error{Op: "Put", URL: "vault://vault", Err: errors.New(`unsupported protocol scheme "vault"`)}, false},
+		"no host":             {&url.Error{Op: "Put", URL: "http:///v1/auth", Err: errors.New("http: no Host in request URL")}, false},
+		"malformed url":       {&url.Error{Op: "parse", URL: "http://vault:port", Err: errors.New(`invalid port ":port" after host`)}, false},
+		"sealed":              {&api.ResponseError{StatusCode: 503}, true},
+		"internal error":      {&api.ResponseError{StatusCode: 500}, true},
+		"rate limited":        {&api.ResponseError{StatusCode: 429}, true},
+		"invalid role":        {&api.ResponseError{StatusCode: 400}, false},
+		"permission denied":   {&api.ResponseError{StatusCode: 403}, false},
+		"other":               {errors.New("could not read token file"), false},
+		"unknown authority":   {&url.Error{Op: "Put", URL: "https://vault", Err: x509.UnknownAuthorityError{}}, false},
+		"wrong hostname":      {&url.Error{Op: "Put", URL: "https://vault", Err: x509.HostnameError{Host: "vault"}}, false},
+		"expired certificate": {&url.Error{Op: "Put", URL: "https://vault", Err: x509.CertificateInvalidError{Reason: x509.Expired}}, false},
+		"iam endpoint unavailable": {&vaultclient.IamLoginError{Attempts: []vaultclient.IamEndpointAttempt{
+			{Endpoint: "https://sts.eu-west-1.amazonaws.com", Err: &api.ResponseError{StatusCode: 400}},
+			{Endpoint: "https://sts.amazonaws.com", Err: &api.ResponseError{StatusCode: 503}},
+		}}, true},
+		"iam endpoints rejected": {&vaultclient.IamLoginError{Attempts: []vaultclient.IamEndpointAttempt{
+			{Endpoint: "https://sts.eu-west-1.amazonaws.com", Err: &api.ResponseError{StatusCode: 400}},
+			{Endpoint: "https://sts.amazonaws.com", Err: &api.ResponseError{StatusCode: 403}},
+		}}, false},
+	}
+
+	for name, c := range cases {
+		if retryable := vaultclient.IsRetryableError(c.err); retryable != c.retryable {
+			t.Errorf("%s: expected retryable to be %t but was %t", name, c.retryable, retryable)
+		}
+	}
+}
+
+func TestLoginRetriesRetryableErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			writeErrorResponse(w, http.StatusServiceUnavailable, "Vault is sealed")
+			return
+		}
+		writeLoginResponse(w, "s.retried", 3600)
+	}))
+	defer server.Close()
+
+	v := newStandInAppRoleAuth(t, server.URL)
+
+	client, err := v.VaultClient()
+	if err != nil {
+		t.Fatalf("expected login to succeed after retrying, error: %s", err)
+	}
+	if client.Token() != "s.retried" {
+		t.Fatalf("expected token s.retried but was %s", client.Token())
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 login attempts but got %d", attempts)
+	}
+}
+
+func TestLoginDoesNotRetryPermanentErrors(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		writeErrorResponse(w, http.StatusBadRequest, "invalid role ID")
+	}))
+	defer server.Close()
+
+	v := newStandInAppRoleAuth(t, server.URL)
+
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected login to fail")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected a single login attempt but got %d", attempts)
+	}
+}
+
+func newStandInAppRoleAuth(t *testing.T, address string) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = address
+	// leave retrying to the login retry policy
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = "roleid"
+	config.AppRoleSecretId = "secretid"
+	config.LoginRetry = &vaultclient.RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+		Jitter:      0.5,
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
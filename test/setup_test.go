@@ -1,8 +1,10 @@
 package test
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"net/http"
 	"os"
 	"testing"
 
@@ -13,6 +15,8 @@ import (
 	"github.com/hashicorp/vault/api"
 	credAppRole "github.com/hashicorp/vault/builtin/credential/approle"
 	vaultaws "github.com/hashicorp/vault/builtin/credential/aws"
+	credCert "github.com/hashicorp/vault/builtin/credential/cert"
+	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
 	vaulthttp "github.com/hashicorp/vault/http"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -153,6 +157,103 @@ func newVaultConfiguredForAppRole(t *testing.T, leaseTtl, maxLeaseTtl string) (*
 	}, deferFunc
 }
 
+func newVaultConfiguredForUserpass(t *testing.T, path, leaseTtl string) (*configuredVault, func()) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		DisableMlock: true,
+		DisableCache: true,
+		Logger:       logger,
+		CredentialBackends: map[string]logical.Factory{
+			"userpass": credUserpass.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+	deferFunc := func() {
+		cluster.Cleanup()
+	}
+
+	err := client.Sys().EnableAuthWithOptions(path, &api.EnableAuthOptions{
+		Type: "userpass",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write(fmt.Sprintf("auth/%s/users/tester", path), map[string]interface{}{
+		"password":  "secret",
+		"token_ttl": leaseTtl,
+		"policies":  "testapppolicy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := `
+	path "secret/baz" {
+  		capabilities = ["read", "create"]
+	}
+`
+	if err := client.Sys().PutPolicy("testapppolicy", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	return &configuredVault{
+		address:    client.Address(),
+		rootToken:  client.Token(),
+		rootClient: client,
+	}, deferFunc
+}
+
+func newVaultConfiguredForCert(t *testing.T, caPEM []byte, leaseTtl string) (*configuredVault, func()) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		DisableMlock: true,
+		DisableCache: true,
+		Logger:       logger,
+		CredentialBackends: map[string]logical.Factory{
+			"cert": credCert.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+	deferFunc := func() {
+		cluster.Cleanup()
+	}
+
+	err := client.Sys().EnableAuthWithOptions("cert", &api.EnableAuthOptions{
+		Type: "cert",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write("auth/cert/certs/workers", map[string]interface{}{
+		"certificate": string(caPEM),
+		"token_ttl":   leaseTtl,
+		"policies":    "testapppolicy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &configuredVault{
+		address:    client.Address(),
+		rootToken:  client.Token(),
+		rootClient: client,
+	}, deferFunc
+}
+
 func newVault(t *testing.T) (*configuredVault, func()) {
 	logger := logging.NewVaultLogger(hclog.Trace)
 	coreConfig := &vault.CoreConfig{
@@ -230,3 +331,60 @@ func setEnv(key, value string) func() {
 		}
 	}
 }
+
+func appRoleCredentials(t *testing.T, configuredVault *configuredVault, role string) (string, string) {
+	resp, err := configuredVault.rootClient.Logical().Write(fmt.Sprintf("auth/approle/role/%s/secret-id", role), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID := resp.Data["secret_id"].(string)
+
+	resp, err = configuredVault.rootClient.Logical().Read(fmt.Sprintf("auth/approle/role/%s/role-id", role))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleID := resp.Data["role_id"].(string)
+
+	return roleID, secretID
+}
+
+// writeLoginResponse answers a stand-in login request the way Vault does.
+func writeLoginResponse(w http.ResponseWriter, token string, ttl int) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"accessor":       token + "-accessor",
+			"lease_duration": ttl,
+			"renewable":      true,
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// writeLookupResponse answers a stand-in token lookup for a token that is not
+// renewable.
+func writeLookupResponse(w http.ResponseWriter, token string, ttl int) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"accessor":  token + "-accessor",
+			"ttl":       ttl,
+			"renewable": false,
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// writeErrorResponse answers a stand-in request with a Vault style error.
+func writeErrorResponse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []string{message},
+	}); err != nil {
+		panic(err)
+	}
+}
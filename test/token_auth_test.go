@@ -1,11 +1,20 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/vault/api"
 )
 
@@ -54,3 +63,340 @@ func TestTokenAuth(t *testing.T) {
 	}
 
 }
+
+func TestTokenAuthRenewsPeriodicTokenAheadOfTime(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	created := time.Now()
+	secret, err := configuredVault.rootClient.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies: []string{"default"},
+		Period:   "6s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTokenAuth(t, configuredVault, secret.Auth.ClientToken)
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	events := make(chan vaultclient.TokenEvent, 10)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})()
+
+	// no call for a client is made while the token is renewed
+	renewed := nextTokenEvent(t, events)
+	if renewed.Type != vaultclient.TokenRenewed {
+		t.Fatalf("expected token renewed event but got %d", renewed.Type)
+	}
+	if renewed.TTL <= 4*time.Second {
+		t.Fatalf("expected token ttl to be extended to its period but was %s", renewed.TTL)
+	}
+
+	// by now the token would have expired had it not been renewed
+	time.Sleep(time.Until(created.Add(8 * time.Second)))
+	if _, err := configuredVault.rootClient.Auth().Token().Lookup(secret.Auth.ClientToken); err != nil {
+		t.Fatalf("expected periodic token to be kept alive, error: %s", err)
+	}
+}
+
+func TestTokenAuthReportsNonRenewableTokenBeforeItExpires(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	renewable := false
+	secret, err := configuredVault.rootClient.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies:  []string{"default"},
+		TTL:       "6s",
+		Renewable: &renewable,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTokenAuth(t, configuredVault, secret.Auth.ClientToken)
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	events := make(chan vaultclient.TokenEvent, 10)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})()
+
+	expiring := nextTokenEvent(t, events)
+	if expiring.Type != vaultclient.TokenExpiring {
+		t.Fatalf("expected token expiring event but got %d", expiring.Type)
+	}
+	var expiringErr *vaultclient.TokenExpiringError
+	if !errors.As(expiring.Err, &expiringErr) || expiringErr.Accessor != secret.Auth.Accessor {
+		t.Fatalf("expected token expiring error for accessor %s but got %v", secret.Auth.Accessor, expiring.Err)
+	}
+
+	// a third of the token's lifetime is left, during which it is still
+	// handed out
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatalf("expected the token to be handed out until it expires, error: %s", err)
+	}
+
+	time.Sleep(time.Until(expiringErr.Expiry) + time.Second)
+	_, err = v.VaultClient()
+	if !errors.As(err, &expiringErr) {
+		t.Fatalf("expected token expiring error once expired but got %v", err)
+	}
+}
+
+func TestTokenAuthReportsFailedRenewalAsLoginFailure(t *testing.T) {
+	var lookups int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Vault becomes unavailable after the first lookup
+		if atomic.AddInt32(&lookups, 1) > 1 {
+			writeErrorResponse(w, http.StatusServiceUnavailable, "Vault is sealed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"accessor":     "s.renewable-accessor",
+				"ttl":          6,
+				"creation_ttl": 6,
+				"renewable":    true,
+			},
+		}); err != nil {
+			panic(err)
+		}
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Token
+	config.Token = "s.renewable"
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	// the token is first looked up, and then watched, once a client is asked for
+	v.VaultClientOrPanic()
+	events := make(chan vaultclient.TokenEvent, 10)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})()
+
+	if failed := nextTokenEvent(t, events); failed.Type != vaultclient.LoginFailed {
+		t.Fatalf("expected login failed event for the failed renewal but got %d", failed.Type)
+	}
+	select {
+	case event := <-events:
+		if event.Type == vaultclient.TokenExpiring {
+			t.Fatalf("expected a renewable token not to be reported as expiring: %s", event.Err)
+		}
+	case <-time.After(time.Second):
+	}
+}
+
+func TestTokenAuthDoesNotReportRenewalThatKeepsExpiry(t *testing.T) {
+	// the token has reached its max TTL, so renewing it does not extend it
+	expiry := time.Now().Add(6 * time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl := int(time.Until(expiry).Seconds())
+		if strings.HasSuffix(r.URL.Path, "/renew-self") {
+			writeLoginResponse(w, "s.renewable", ttl)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"accessor":     "s.renewable-accessor",
+				"ttl":          ttl,
+				"creation_ttl": 6,
+				"renewable":    true,
+			},
+		}); err != nil {
+			panic(err)
+		}
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.AuthType = vaultclient.Token
+	config.Token = "s.renewable"
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	// the token is first looked up, and then watched, once a client is asked for
+	v.VaultClientOrPanic()
+	events := make(chan vaultclient.TokenEvent, 10)
+	defer v.(vaultclient.SubscribableVaultAuth).Subscribe(func(event vaultclient.TokenEvent) {
+		events <- event
+	})()
+
+	if expiring := nextTokenEvent(t, events); expiring.Type != vaultclient.TokenExpiring {
+		t.Fatalf("expected token expiring event but got %d", expiring.Type)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected no further event but got %d", event.Type)
+	case <-time.After(time.Until(expiry)):
+	}
+}
+
+func TestTokenAuthBackgroundRenewalLeavesTokenToItsWatcher(t *testing.T) {
+	var renewals int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/renew-self") {
+			atomic.AddInt32(&renewals, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"accessor":     "s.renewable-accessor",
+				"ttl":          60,
+				"creation_ttl": 60,
+				"renewable":    true,
+			},
+		}); err != nil {
+			panic(err)
+		}
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.AuthType = vaultclient.Token
+	config.Token = "s.renewable"
+	config.BackgroundRenewal = true
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	// the token is first looked up, and then watched, once a client is asked for
+	v.VaultClientOrPanic()
+
+	// a background renewer would renew the token as soon as it started
+	time.Sleep(time.Second)
+	if n := atomic.LoadInt32(&renewals); n != 0 {
+		t.Fatalf("expected the token to be left to the token auth watcher but it was renewed %d times", n)
+	}
+}
+
+func TestTokenAuthFailsForInvalidToken(t *testing.T) {
+	configuredVault, destroy := newVault(t)
+	defer destroy()
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Token
+	config.Token = "not-a-token"
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected looking up an invalid token to fail")
+	}
+}
+
+func TestTokenAuthRetriesFirstLookup(t *testing.T) {
+	var lookups int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&lookups, 1) < 3 {
+			writeErrorResponse(w, http.StatusServiceUnavailable, "Vault is sealed")
+			return
+		}
+		writeLookupResponse(w, "s.token", 0)
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Token
+	config.Token = "s.token"
+	config.LoginRetry = &vaultclient.RetryPolicy{
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookups := atomic.LoadInt32(&lookups); lookups != 0 {
+		t.Fatalf("expected the token to be looked up on first use but it was looked up %d times", lookups)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatalf("expected the lookup to be retried, error: %s", err)
+	}
+	if lookups := atomic.LoadInt32(&lookups); lookups != 3 {
+		t.Fatalf("expected 3 lookups but got %d", lookups)
+	}
+}
+
+func TestTokenAuthFailingLookupDoesNotLeakWatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no idle connections to count against the watchers
+		w.Header().Set("Connection", "close")
+		writeErrorResponse(w, http.StatusForbidden, "permission denied")
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Token
+	config.Token = "s.revoked"
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		v, err := vaultclient.NewVaultAuth(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.VaultClient(); err == nil {
+			t.Fatalf("expected looking up a revoked token to fail")
+		}
+	}
+	retry.RunWith(&retry.Timer{Timeout: 5 * time.Second, Wait: 100 * time.Millisecond}, t, func(r *retry.R) {
+		if leaked := runtime.NumGoroutine() - before; leaked >= 20 {
+			r.Fatalf("expected failed lookups not to watch the token but %d goroutines are left", leaked)
+		}
+	})
+}
+
+func newTokenAuth(t *testing.T, configuredVault *configuredVault, token string) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Token
+	config.Token = token
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token is first looked up, and then watched, once a client is asked for
+	v.VaultClientOrPanic()
+	return v
+}
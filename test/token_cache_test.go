@@ -0,0 +1,323 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTokenCacheIsReusedByNextProcess(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	// a secret id that can only be used once fails any login after the first
+	resp, err := configuredVault.rootClient.Logical().Write("auth/approle/role/test1/secret-id", map[string]interface{}{
+		"num_uses": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID := resp.Data["secret_id"].(string)
+	roleID, _ := appRoleCredentials(t, configuredVault, "test1")
+
+	first := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "key")
+	token := first.VaultClientOrPanic().Token()
+
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		t.Fatalf("expected token cache to be written, error: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected token cache to only be readable by the user but mode was %s", info.Mode())
+	}
+	contents, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte(token)) {
+		t.Fatalf("expected token cache to be encrypted")
+	}
+
+	second := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "key")
+	client, err := second.VaultClient()
+	if err != nil {
+		t.Fatalf("expected cached token to be reused, error: %s", err)
+	}
+	if client.Token() != token {
+		t.Fatalf("expected cached token to be used")
+	}
+}
+
+func TestTokenCacheFallsBackToLogin(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+
+	first := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "key")
+	revoked := first.VaultClientOrPanic().Token()
+	if err := configuredVault.rootClient.Auth().Token().RevokeOrphan(revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "key")
+	if token := second.VaultClientOrPanic().Token(); token == revoked {
+		t.Fatalf("expected a revoked cached token to be replaced by logging in")
+	}
+
+	third := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "another key")
+	if _, err := third.VaultClient(); err != nil {
+		t.Fatalf("expected a cache encrypted with another key to be ignored, error: %s", err)
+	}
+
+	if err := third.(vaultclient.ClosableVaultAuth).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Fatalf("expected token cache to be removed once its token is revoked")
+	}
+}
+
+func TestTokenCacheIsNotSharedBetweenIdentities(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForAppRole(t, "1h", "1h")
+	defer destroy()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	_, err := configuredVault.rootClient.Logical().Write("auth/approle/role/test2", map[string]interface{}{
+		"bind_secret_id": "true",
+		"policies":       "default",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleID, secretID := appRoleCredentials(t, configuredVault, "test1")
+	first := newCachingAppRoleAuth(t, configuredVault, cachePath, roleID, secretID, "key")
+	token := first.VaultClientOrPanic().Token()
+
+	otherRoleID, otherSecretID := appRoleCredentials(t, configuredVault, "test2")
+	second := newCachingAppRoleAuth(t, configuredVault, cachePath, otherRoleID, otherSecretID, "key")
+	client, err := second.VaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token() == token {
+		t.Fatalf("expected a token cached for another role to be ignored")
+	}
+
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := lookup.Data["meta"].(map[string]interface{}); meta["role_name"] != "test2" {
+		t.Fatalf("expected token for role test2 but got %v", lookup.Data["meta"])
+	}
+}
+
+func TestTokenCacheIsKeyedOnIamCredentials(t *testing.T) {
+	defer setEnv(vaultclient.EnvVarAwsRegion, awsTestRegion)()
+
+	var headers []http.Header
+	iam := newStandInIamHandler("aws", &headers)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/token/lookup-self" {
+			writeLookupResponse(w, "iam-token", 3600)
+			return
+		}
+		iam.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	for _, accessKeyId := range []string{"AKIDFIRST", "AKIDFIRST", "AKIDSECOND"} {
+		config := newStandInIamConfig(server.URL)
+		config.IamCredentials = credentials.NewStaticCredentials(accessKeyId, "secret", "")
+		config.TokenCachePath = cachePath
+		config.TokenCacheKey = func() ([]byte, error) {
+			return []byte("key"), nil
+		}
+		v, err := vaultclient.NewVaultAuth(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.VaultClient(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(headers) != 2 {
+		t.Fatalf("expected a login for each access key but got %d", len(headers))
+	}
+	if authorization := headers[1].Get("Authorization"); !strings.Contains(authorization, "Credential=AKIDSECOND/") {
+		t.Fatalf("expected second login signed by AKIDSECOND but got %s", authorization)
+	}
+}
+
+func TestTokenCacheNeedsMethodIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/token/lookup-self" {
+			writeLookupResponse(w, "s.direct", 3600)
+			return
+		}
+		writeLoginResponse(w, "s.direct", 3600)
+	}))
+	defer server.Close()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.TokenCachePath = cachePath
+	config.TokenCacheKey = func() ([]byte, error) {
+		return []byte("key"), nil
+	}
+
+	v, err := vaultclient.NewVaultAuthWithMethod(config, &customAuth{name: "direct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.VaultClientOrPanic()
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Fatalf("expected no token cache for a method without an identity")
+	}
+
+	v, err = vaultclient.NewVaultAuthWithMethod(config, &identifiedCustomAuth{customAuth{name: "direct"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.VaultClientOrPanic()
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("expected token cache for a method with an identity, error: %s", err)
+	}
+}
+
+// identifiedCustomAuth is a customAuth whose tokens can be cached.
+type identifiedCustomAuth struct {
+	customAuth
+}
+
+func (c *identifiedCustomAuth) CacheIdentity() string {
+	return "custom " + c.name
+}
+
+func newCachingAppRoleAuth(t *testing.T, configuredVault *configuredVault, cachePath, roleID, secretID, key string) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.AppRole
+	config.AppRoleId = roleID
+	config.AppRoleSecretId = secretID
+	config.TokenCachePath = cachePath
+	config.TokenCacheKey = func() ([]byte, error) {
+		return []byte(key), nil
+	}
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func tempTokenCachePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "token-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "cache", "token"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTokenCacheIsNotRewrittenOnRenewal(t *testing.T) {
+	renewed := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeLoginResponse(w, "s.renewed", 2)
+		if r.URL.Path == "/v1/auth/token/renew-self" {
+			renewed <- struct{}{}
+		}
+	}))
+	defer server.Close()
+
+	cachePath, cleanup := tempTokenCachePath(t)
+	defer cleanup()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.BackgroundRenewal = true
+	config.TokenCachePath = cachePath
+	config.TokenCacheKey = func() ([]byte, error) {
+		return []byte("key"), nil
+	}
+
+	v, err := vaultclient.NewVaultAuthWithMethod(config, &identifiedCustomAuth{customAuth{name: "renewed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.(vaultclient.ClosableVaultAuth).Close(context.Background())
+	v.VaultClientOrPanic()
+
+	written, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every write uses a fresh nonce, so a rewrite changes the file even when
+	// the token is the same
+	for i := 0; i < 2; i++ {
+		select {
+		case <-renewed:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for the token to be renewed")
+		}
+	}
+	contents, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, contents) {
+		t.Fatalf("expected token cache not to be written again for a renewed token")
+	}
+}
+
+func TestTokenCacheRequiresKey(t *testing.T) {
+	config := vaultclient.BaseConfig()
+	config.TokenCachePath = "/tmp/token"
+
+	if _, err := vaultclient.NewVaultAuthWithMethod(config, &identifiedCustomAuth{customAuth{name: "direct"}}); err == nil {
+		t.Fatalf("expected a token cache without a key to fail")
+	}
+
+	config.TokenCacheKey = vaultclient.TokenCacheKeyFromEnv("VAULT_TOKEN_CACHE_KEY_TEST")
+	if _, err := vaultclient.NewVaultAuthWithMethod(config, &identifiedCustomAuth{customAuth{name: "direct"}}); err == nil {
+		t.Fatalf("expected a token cache whose key cannot be read to fail")
+	}
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"github.com/form3tech-oss/go-vault-client/v4/pkg/vaultclient"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestUserpassAuth(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForUserpass(t, "userpass", "1h")
+	defer destroy()
+
+	v := newUserpassAuth(t, configuredVault, "")
+
+	if _, err := v.VaultClientOrPanic().Logical().Write("secret/baz", map[string]interface{}{
+		"baz": "buzz",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := v.VaultClientOrPanic().Logical().Read("secret/baz")
+	if err != nil {
+		t.Fatalf("could not read secret using authed client, error: %s", err)
+	}
+
+	if result == nil {
+		t.Fatalf("no secret returned")
+	}
+
+	if !strings.EqualFold(result.Data["baz"].(string), "buzz") {
+		t.Fatalf("expecting secret to be buzz")
+	}
+}
+
+func TestUserpassAuthWithCustomMount(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForUserpass(t, "people", "1h")
+	defer destroy()
+
+	v := newUserpassAuth(t, configuredVault, "people")
+
+	if _, err := v.VaultClientOrPanic().Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected token to be valid, error: %s", err)
+	}
+}
+
+func TestUserpassAuthLogsInAgainWhenTokenExpires(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForUserpass(t, "userpass", "12s")
+	defer destroy()
+
+	v := newUserpassAuth(t, configuredVault, "")
+
+	token := v.VaultClientOrPanic().Token()
+
+	// wait until the token is within the expiration window
+	time.Sleep(time.Second * 3)
+
+	client := v.VaultClientOrPanic()
+	if client.Token() == token {
+		t.Fatalf("expected a new token once the old one is close to expiry")
+	}
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		t.Fatalf("expected new token to be valid, error: %s", err)
+	}
+}
+
+func TestUserpassAuthWithWrongPassword(t *testing.T) {
+	configuredVault, destroy := newVaultConfiguredForUserpass(t, "userpass", "1h")
+	defer destroy()
+
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Userpass
+	config.UserpassUsername = "tester"
+	config.UserpassPassword = "wrong"
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.VaultClient(); err == nil {
+		t.Fatalf("expected login with the wrong password to fail")
+	}
+}
+
+func TestUserpassAuthEscapesUsername(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/v1/auth/userpass/login/jane%2F..%3Fdoe" {
+			writeErrorResponse(w, http.StatusNotFound, "unexpected path "+r.URL.EscapedPath())
+			return
+		}
+		writeLoginResponse(w, "s.userpass", 3600)
+	}))
+	defer server.Close()
+
+	config := vaultclient.BaseConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	config.AuthType = vaultclient.Userpass
+	config.UserpassUsername = "jane/..?doe"
+	config.UserpassPassword = "secret"
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.VaultClient(); err != nil {
+		t.Fatalf("expected login with the escaped username to succeed, error: %s", err)
+	}
+}
+
+func TestUserpassAuthRequiresUsernameAndPassword(t *testing.T) {
+	for _, credentials := range [][2]string{{"", "secret"}, {"..", "secret"}, {"tester", ""}} {
+		config := vaultclient.BaseConfig()
+		config.AuthType = vaultclient.Userpass
+		config.UserpassUsername = credentials[0]
+		config.UserpassPassword = credentials[1]
+
+		if _, err := vaultclient.NewVaultAuth(config); err == nil {
+			t.Fatalf("expected userpass auth as '%s' with password '%s' to fail", credentials[0], credentials[1])
+		}
+	}
+}
+
+func newUserpassAuth(t *testing.T, configuredVault *configuredVault, path string) vaultclient.VaultAuth {
+	config := vaultclient.BaseConfig()
+	config.Address = configuredVault.address
+	config.AuthType = vaultclient.Userpass
+	config.UserpassUsername = "tester"
+	config.UserpassPassword = "secret"
+	config.UserpassPath = path
+
+	err := config.ConfigureTLS(&api.TLSConfig{
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vaultclient.NewVaultAuth(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}